@@ -2,18 +2,38 @@ package main
 
 import (
 	"C"
-	"encoding/json"
+	"context"
 	"log"
+	"os"
+	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
 	"github.com/fluent/fluent-bit-go/output"
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/controller"
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/plugin"
 	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
 )
 
+// sinkResourcesResyncInterval is how often the sinks declared by Sink and
+// ClusterSink resources are applied again to pick up rotated TLS secrets.
+const sinkResourcesResyncInterval = 5 * time.Minute
+
+// instance is an initialized plugin instance.
+type instance struct {
+	out         *syslog.Out
+	gracePeriod time.Duration
+	// stop stops watching the SinksFile or the sink resources.
+	stop chan struct{}
+}
+
+var (
+	instancesMu sync.Mutex
+	instances   []instance
+)
+
 //export FLBPluginRegister
 func FLBPluginRegister(def unsafe.Pointer) int {
 	return output.FLBPluginRegister(
@@ -24,63 +44,51 @@ func FLBPluginRegister(def unsafe.Pointer) int {
 }
 
 //export FLBPluginInit
-func FLBPluginInit(plugin unsafe.Pointer) int {
-	addr := output.FLBPluginConfigKey(plugin, "addr")
-	name := output.FLBPluginConfigKey(plugin, "instancename")
-	namespace := output.FLBPluginConfigKey(plugin, "namespace")
-	cluster := output.FLBPluginConfigKey(plugin, "cluster")
-	tls := output.FLBPluginConfigKey(plugin, "tlsconfig")
-	sanitizeHost := output.FLBPluginConfigKey(plugin, "sanitizehost")
-
-	if addr == "" {
-		log.Println("[out_syslog] ERROR: Addr is required")
-		return output.FLB_ERROR
-	}
-	if name == "" {
-		log.Println("[out_syslog] ERROR: InstanceName is required")
+func FLBPluginInit(p unsafe.Pointer) int {
+	cfg, err := plugin.Parse(func(key string) string {
+		return output.FLBPluginConfigKey(p, key)
+	})
+	if err != nil {
+		log.Printf("[out_syslog] ERROR: %s", err)
 		return output.FLB_ERROR
 	}
 
-	var (
-		sinks        []*syslog.Sink
-		clusterSinks []*syslog.Sink
-	)
-
-	sink := &syslog.Sink{
-		Addr:      addr,
-		Name:      name,
-		Namespace: namespace,
-	}
-	if tls != "" {
-		var tlsConfig syslog.TLS
-		err := json.Unmarshal([]byte(tls), &tlsConfig)
+	var c *controller.Controller
+	if cfg.SinkResources {
+		c, err = controller.InCluster()
 		if err != nil {
-			log.Printf("[out_syslog] ERROR: Unable to unmarshal TLS config: %s", err)
-			return output.FLB_ERROR
-		}
-		sink.TLS = &tlsConfig
-	}
-	if strings.ToLower(cluster) == "true" {
-		clusterSinks = append(clusterSinks, sink)
-	} else {
-		sinks = append(sinks, sink)
-	}
-
-	// Defaults to true so that plugin conforms better with rfc5424#section-6.2.4
-	sanitize := true
-	if len(sanitizeHost) != 0 {
-		var err error
-		sanitize, err = strconv.ParseBool(sanitizeHost)
-		if err != nil {
-			log.Printf("[out_syslog] ERROR: Unable to parse SanitizeHost: %s", err)
+			log.Printf("[out_syslog] ERROR: Unable to watch sink resources: %s", err)
 			return output.FLB_ERROR
 		}
 	}
+	sinks, clusterSinks := cfg.Sinks()
 	out := syslog.NewOut(
 		sinks,
 		clusterSinks,
-		syslog.WithSanitizeHost(sanitize),
+		cfg.Options...,
 	)
+	stop := make(chan struct{})
+	if cfg.SinksFile != nil && cfg.SinksFileReloadInterval > 0 {
+		cfg.SinksFile.Out = out
+		cfg.SinksFile.GracePeriod = cfg.GracePeriod
+		go cfg.SinksFile.Run(cfg.SinksFileReloadInterval, stop)
+	}
+	if cfg.SinkResources {
+		c.SecretDir = filepath.Join(os.TempDir(), "out-syslog", cfg.Name)
+		c.Out = out
+		c.Sinks = sinks
+		c.ClusterSinks = clusterSinks
+		c.GracePeriod = cfg.GracePeriod
+		c.ResyncInterval = sinkResourcesResyncInterval
+		go c.Run(stop)
+	}
+	instancesMu.Lock()
+	instances = append(instances, instance{
+		out:         out,
+		gracePeriod: cfg.GracePeriod,
+		stop:        stop,
+	})
+	instancesMu.Unlock()
 
 	// We are using runtime.KeepAlive to tell the Go Runtime to keep the
 	// reference to this pointer because once it leaves this context and
@@ -96,12 +104,20 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 	// NOTE 2: Since we are asking the Go Runtime to not clean this memory
 	// up, it can be a cause for a "memory leak" however we are not planning
 	// on millions of sinks to be initialized.
-	output.FLBPluginSetContext(plugin, unsafe.Pointer(out))
+	output.FLBPluginSetContext(p, unsafe.Pointer(out))
 	runtime.KeepAlive(out)
-	if strings.ToLower(cluster) == "true" {
-		log.Printf("[out_syslog] Initializing plugin %s for cluster to destination %s", name, addr)
-	} else {
-		log.Printf("[out_syslog] Initializing plugin %s for namespace %s to destination %s", name, namespace, addr)
+	switch {
+	case cfg.Sink == nil:
+	case cfg.Cluster:
+		log.Printf("[out_syslog] Initializing plugin %s for cluster to destination %s", cfg.Name, cfg.Sink.Addr)
+	default:
+		log.Printf("[out_syslog] Initializing plugin %s for namespace %s to destination %s", cfg.Name, cfg.Sink.Namespace, cfg.Sink.Addr)
+	}
+	if cfg.SinksFile != nil {
+		log.Printf("[out_syslog] Initializing plugin %s with %d namespace and %d cluster sinks from %s", cfg.Name, len(cfg.FileSinks), len(cfg.FileClusterSinks), cfg.SinksFile.Path)
+	}
+	if cfg.SinkResources {
+		log.Printf("[out_syslog] Initializing plugin %s with sinks from Sink and ClusterSink resources", cfg.Name)
 	}
 	return output.FLB_OK
 }
@@ -109,9 +125,10 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 //export FLBPluginFlushCtx
 func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int {
 	var (
-		ret    int
-		ts     interface{}
-		record map[interface{}]interface{}
+		ret     int
+		ts      interface{}
+		record  map[interface{}]interface{}
+		records []syslog.Record
 	)
 
 	out := (*syslog.Out)(ctx)
@@ -135,15 +152,43 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 			timestamp = time.Now()
 		}
 
-		out.Write(record, timestamp, C.GoString(tag))
+		records = append(records, syslog.Record{
+			Record: record,
+			Time:   timestamp,
+		})
 	}
 
+	if !out.WriteChunk(records, C.GoString(tag)) && out.Backpressure() {
+		// Let fluent-bit buffer the chunk and flush it again later. None
+		// of its records were queued.
+		return output.FLB_RETRY
+	}
 	return output.FLB_OK
 }
 
 //export FLBPluginExit
 func FLBPluginExit() int {
-	// TODO: We should probably call conn.Close() for each sink connection
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+
+	// Every instance sends the messages left in its queues within its own
+	// grace period.
+	var wg sync.WaitGroup
+	for _, i := range instances {
+		wg.Add(1)
+		go func(i instance) {
+			defer wg.Done()
+			close(i.stop)
+			ctx, cancel := context.WithTimeout(context.Background(), i.gracePeriod)
+			defer cancel()
+			err := i.out.Close(ctx)
+			if err != nil {
+				log.Printf("[out_syslog] Abandoned queued messages on exit: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	instances = nil
 	return output.FLB_OK
 }
 
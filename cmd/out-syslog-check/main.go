@@ -0,0 +1,117 @@
+// Command out-syslog-check validates the syslog outputs of a fluent-bit
+// configuration file without starting fluent-bit.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/plugin"
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("out-syslog-check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dial := flags.Bool("dial", false, "connect to every sink once")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: out-syslog-check [-dial] <fluent-bit.conf>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	sections, err := plugin.LoadFluentBitConfig(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to read %s: %s\n", flags.Arg(0), err)
+		return 2
+	}
+
+	var outputs, failed int
+	for _, s := range sections {
+		if !s.IsSyslogOutput() {
+			continue
+		}
+		outputs++
+		if !check(s, *dial, stdout) {
+			failed++
+		}
+	}
+
+	if outputs == 0 {
+		fmt.Fprintf(stderr, "%s has no syslog outputs\n", flags.Arg(0))
+		return 1
+	}
+	if failed != 0 {
+		fmt.Fprintf(stdout, "\n%d of %d syslog outputs have errors\n", failed, outputs)
+		return 1
+	}
+	fmt.Fprintf(stdout, "\n%d syslog outputs ok\n", outputs)
+	return 0
+}
+
+// check prints the report of a syslog output section and returns whether
+// it has no errors.
+func check(s *plugin.Section, dial bool, w io.Writer) bool {
+	name := s.Get("instancename")
+	if name == "" {
+		name = "(no InstanceName)"
+	}
+	fmt.Fprintf(w, "[OUTPUT] %s (%s:%d)\n", name, s.File, s.Line)
+
+	ok := true
+	for _, k := range s.UnknownKeys() {
+		fmt.Fprintf(w, "  ERROR unknown key %s\n", k)
+		ok = false
+	}
+
+	cfg, err := plugin.Parse(s.Get)
+	if err != nil {
+		fmt.Fprintf(w, "  ERROR %s\n", err)
+		return false
+	}
+	if cfg.SinkResources {
+		fmt.Fprintln(w, "  sinks from Sink and ClusterSink resources are not checked")
+	}
+
+	out := syslog.NewOut(nil, nil, cfg.Options...)
+	sinks, clusterSinks := cfg.Sinks()
+	report := func(sink *syslog.Sink, scope string) {
+		fmt.Fprintf(w, "  sink %s (%s) %s: ", sink.Name, scope, sink.Addr)
+		err := sink.CheckTLS()
+		if err != nil {
+			fmt.Fprintf(w, "ERROR tls: %s\n", err)
+			ok = false
+			return
+		}
+		if dial {
+			err = out.Dial(sink)
+			if err != nil {
+				fmt.Fprintf(w, "ERROR dial: %s\n", err)
+				ok = false
+				return
+			}
+			fmt.Fprintln(w, "ok, reachable")
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+	for _, sink := range sinks {
+		report(sink, "namespace "+sink.Namespace)
+	}
+	for _, sink := range clusterSinks {
+		report(sink, "cluster")
+	}
+	return ok
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("run", func() {
+	var (
+		dir            string
+		stdout, stderr *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "out-syslog-check")
+		Expect(err).ToNot(HaveOccurred())
+		stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	write := func(content string) string {
+		path := filepath.Join(dir, "fluent-bit.conf")
+		ExpectWithOffset(1, ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("reports valid syslog outputs", func() {
+		path := write(`
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  tenant-a
+    Namespace     tenant-a
+    Addr          localhost:514
+
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  audit
+    Cluster       true
+    Addr          localhost:1514
+`)
+
+		Expect(run([]string{path}, stdout, stderr)).To(Equal(0))
+		Expect(stdout.String()).To(Equal("" +
+			"[OUTPUT] tenant-a (" + path + ":2)\n" +
+			"  sink tenant-a (namespace tenant-a) localhost:514: ok\n" +
+			"[OUTPUT] audit (" + path + ":9)\n" +
+			"  sink audit (cluster) localhost:1514: ok\n" +
+			"\n2 syslog outputs ok\n"))
+		Expect(stderr.String()).To(BeEmpty())
+	})
+
+	It("reports the errors of syslog outputs", func() {
+		path := write(`
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  tenant-a
+    Adr           localhost:514
+
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  tenant-b
+    Addr          localhost:514
+    Transport     carrier-pigeon
+
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  tenant-c
+    Addr          localhost:514
+`)
+
+		Expect(run([]string{path}, stdout, stderr)).To(Equal(1))
+		Expect(stdout.String()).To(Equal("" +
+			"[OUTPUT] tenant-a (" + path + ":2)\n" +
+			"  ERROR unknown key Adr\n" +
+			"  ERROR Addr, SinksFile or SinkResources is required\n" +
+			"[OUTPUT] tenant-b (" + path + ":8)\n" +
+			"  ERROR invalid sink configuration: unsupported transport \"carrier-pigeon\"\n" +
+			"[OUTPUT] tenant-c (" + path + ":15)\n" +
+			"  sink tenant-c (namespace ) localhost:514: ok\n" +
+			"\n2 of 3 syslog outputs have errors\n"))
+	})
+
+	It("connects to every sink with -dial", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		closed.Close()
+
+		path := write(`
+[OUTPUT]
+    Name          syslog
+    InstanceName  reachable
+    Addr          ` + lis.Addr().String() + `
+
+[OUTPUT]
+    Name          syslog
+    InstanceName  unreachable
+    Addr          ` + closed.Addr().String() + `
+    DialTimeout   1s
+`)
+
+		Expect(run([]string{"-dial", path}, stdout, stderr)).To(Equal(1))
+		Expect(stdout.String()).To(ContainSubstring(
+			"  sink reachable (namespace ) " + lis.Addr().String() + ": ok, reachable\n",
+		))
+		Expect(stdout.String()).To(ContainSubstring(
+			"  sink unreachable (namespace ) " + closed.Addr().String() + ": ERROR dial: ",
+		))
+		Expect(stdout.String()).To(HaveSuffix("\n1 of 2 syslog outputs have errors\n"))
+	})
+
+	It("reports files without syslog outputs", func() {
+		path := write(`
+[OUTPUT]
+    Name   stdout
+    Match  *
+`)
+
+		Expect(run([]string{path}, stdout, stderr)).To(Equal(1))
+		Expect(stdout.String()).To(BeEmpty())
+		Expect(stderr.String()).To(Equal(path + " has no syslog outputs\n"))
+	})
+
+	It("reports unreadable files", func() {
+		path := filepath.Join(dir, "missing.conf")
+
+		Expect(run([]string{path}, stdout, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(HavePrefix("unable to read " + path + ": "))
+	})
+
+	It("prints its usage without a file", func() {
+		Expect(run(nil, stdout, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(HavePrefix("usage: out-syslog-check [-dial] <fluent-bit.conf>\n"))
+
+		stderr.Reset()
+		Expect(run([]string{"-unknown"}, stdout, stderr)).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("usage: out-syslog-check"))
+	})
+})
@@ -0,0 +1,611 @@
+// Package controller applies the sinks declared by Sink and ClusterSink
+// custom resources to a running syslog.Out.
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// Group and Version of the Sink and ClusterSink custom resources.
+const (
+	Group   = "apps.pivotal.io"
+	Version = "v1beta1"
+)
+
+// Resources of the Sink and ClusterSink kinds.
+const (
+	resourceSinks        = "sinks"
+	resourceClusterSinks = "clustersinks"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+const (
+	defaultRetryInterval  = 5 * time.Second
+	defaultRequestTimeout = 30 * time.Second
+)
+
+// errExpired is returned by watchFrom when the resource version it
+// watches from is too old.
+var errExpired = errors.New("resource version expired")
+
+// SinkSpec is the spec of a Sink or ClusterSink resource.
+type SinkSpec struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Transport string `json:"transport"`
+	Framing   string `json:"framing"`
+	Format    string `json:"format"`
+	// EnableTLS makes the sink connect with TLS. It is implied by
+	// TLSSecret.
+	EnableTLS          bool   `json:"enable_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	ServerName         string `json:"server_name"`
+	// TLSSecret is the name of a secret with the CA (ca.crt) and the
+	// client certificate (tls.crt and tls.key) of the sink. The secret of
+	// a Sink is in its namespace, the secret of a ClusterSink in the
+	// namespace of the controller.
+	TLSSecret string `json:"tls_secret"`
+}
+
+type metadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type resource struct {
+	Metadata metadata `json:"metadata"`
+	Spec     SinkSpec `json:"spec"`
+}
+
+type resourceList struct {
+	Metadata metadata   `json:"metadata"`
+	Items    []resource `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type secret struct {
+	Metadata metadata          `json:"metadata"`
+	Data     map[string][]byte `json:"data"`
+}
+
+// secretKey is the namespace and name of a secret.
+type secretKey struct {
+	namespace, name string
+}
+
+// cachedSecret is a secret the controller read and the resource version of
+// it that was written to SecretDir.
+type cachedSecret struct {
+	secret
+	written string
+}
+
+// Controller watches Sink and ClusterSink resources and reloads the sinks
+// of Out whenever they change.
+type Controller struct {
+	// Host is the URL of the Kubernetes API server.
+	Host   string
+	Client *http.Client
+	// TokenFile holds the bearer token the controller authenticates
+	// with. It is read for every request so that rotated tokens are
+	// picked up.
+	TokenFile string
+	// Namespace holds the TLS secrets of ClusterSinks.
+	Namespace string
+	// SecretDir is the directory the TLS secrets of sinks are written to.
+	SecretDir string
+
+	Out *syslog.Out
+	// Sinks and ClusterSinks are kept in addition to the sinks declared
+	// by resources.
+	Sinks        []*syslog.Sink
+	ClusterSinks []*syslog.Sink
+	// GracePeriod is how long removed sinks may take to send their
+	// queued messages.
+	GracePeriod time.Duration
+	// ResyncInterval is how often the sinks are applied again, which picks
+	// up rotated TLS secrets. Secrets are only read again on resync.
+	ResyncInterval time.Duration
+	// RetryInterval is how long the controller waits before it lists
+	// resources again after a failed list or watch, 5s by default.
+	RetryInterval time.Duration
+	// RequestTimeout is how long a request other than a watch may take,
+	// 30s by default.
+	RequestTimeout time.Duration
+
+	mu        sync.Mutex
+	resources map[string]map[string]resource
+	changed   chan struct{}
+	// secrets is only used by the goroutine running apply.
+	secrets map[secretKey]*cachedSecret
+}
+
+// InCluster returns a controller that talks to the API server of the
+// cluster the plugin runs in using its service account.
+func InCluster() (*Controller, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster")
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in service account ca.crt")
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The client has no Timeout as it would end watches. Requests other
+	// than watches are bounded by RequestTimeout instead.
+	return &Controller{
+		Host: "https://" + net.JoinHostPort(host, port),
+		Client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSClientConfig:       &tls.Config{RootCAs: pool},
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: defaultRequestTimeout,
+			},
+		},
+		TokenFile: filepath.Join(serviceAccountDir, "token"),
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// Run lists and watches the resources and applies them to Out until stop
+// is closed.
+func (c *Controller) Run(stop <-chan struct{}) {
+	c.mu.Lock()
+	c.resources = make(map[string]map[string]resource)
+	c.changed = make(chan struct{}, 1)
+	c.mu.Unlock()
+	c.secrets = make(map[secretKey]*cachedSecret)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, r := range []string{resourceSinks, resourceClusterSinks} {
+		go c.watch(ctx, r)
+	}
+
+	var resync <-chan time.Time
+	if c.ResyncInterval > 0 {
+		ticker := time.NewTicker(c.ResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+	for {
+		select {
+		case <-c.changed:
+			c.apply(false)
+		case <-resync:
+			c.apply(true)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// watch keeps the resources of the given type up to date until ctx is
+// done.
+func (c *Controller) watch(ctx context.Context, r string) {
+	retry := c.RetryInterval
+	if retry <= 0 {
+		retry = defaultRetryInterval
+	}
+	for {
+		version, err := c.list(ctx, r)
+		if err == nil {
+			err = c.watchFrom(ctx, r, version)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == errExpired {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to watch %s: %s\n", r, err)
+			select {
+			case <-time.After(retry):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// list replaces the resources of the given type and returns their
+// resource version.
+func (c *Controller) list(ctx context.Context, r string) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	resp, err := c.get(ctx, c.resourceURL(r, nil))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var l resourceList
+	err = json.NewDecoder(resp.Body).Decode(&l)
+	if err != nil {
+		return "", err
+	}
+	resources := make(map[string]resource)
+	for _, item := range l.Items {
+		resources[key(item)] = item
+	}
+
+	c.mu.Lock()
+	c.resources[r] = resources
+	c.mu.Unlock()
+	c.notify()
+	return l.Metadata.ResourceVersion, nil
+}
+
+// watchFrom applies the changes to the resources of the given type since
+// version until the API server ends the watch.
+func (c *Controller) watchFrom(ctx context.Context, r, version string) error {
+	resp, err := c.get(ctx, c.resourceURL(r, url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {version},
+	}))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e watchEvent
+		err := dec.Decode(&e)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return nil
+		}
+
+		var item resource
+		switch e.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			err = json.Unmarshal(e.Object, &item)
+			if err != nil {
+				return err
+			}
+		case "ERROR":
+			// The resources are listed again right away if the
+			// resource version is too old.
+			var status struct {
+				Code int `json:"code"`
+			}
+			_ = json.Unmarshal(e.Object, &status)
+			if status.Code == http.StatusGone {
+				return errExpired
+			}
+			return fmt.Errorf("watch failed: %s", e.Object)
+		default:
+			continue
+		}
+
+		c.mu.Lock()
+		if e.Type == "DELETED" {
+			delete(c.resources[r], key(item))
+		} else {
+			c.resources[r][key(item)] = item
+		}
+		c.mu.Unlock()
+		c.notify()
+	}
+}
+
+func (c *Controller) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// apply reloads the sinks of Out from the resources. Resources that do not
+// declare a valid sink are skipped. Secrets that were read before are only
+// read again on resync.
+func (c *Controller) apply(resync bool) {
+	c.mu.Lock()
+	var sinks, clusterSinks []resource
+	for _, item := range c.resources[resourceSinks] {
+		sinks = append(sinks, item)
+	}
+	for _, item := range c.resources[resourceClusterSinks] {
+		clusterSinks = append(clusterSinks, item)
+	}
+	c.mu.Unlock()
+
+	keys := make(map[secretKey]bool)
+	for _, item := range sinks {
+		if item.Spec.TLSSecret != "" {
+			keys[c.secretKey(item, false)] = true
+		}
+	}
+	for _, item := range clusterSinks {
+		if item.Spec.TLSSecret != "" {
+			keys[c.secretKey(item, true)] = true
+		}
+	}
+	errs := c.loadSecrets(keys, resync)
+
+	ns := append([]*syslog.Sink(nil), c.Sinks...)
+	for _, item := range sorted(sinks) {
+		s, err := c.sink(item, false, errs)
+		if err != nil {
+			log.Printf("Skipping sink %s: %s\n", key(item), err)
+			continue
+		}
+		ns = append(ns, s)
+	}
+	cs := append([]*syslog.Sink(nil), c.ClusterSinks...)
+	for _, item := range sorted(clusterSinks) {
+		s, err := c.sink(item, true, errs)
+		if err != nil {
+			log.Printf("Skipping cluster sink %s: %s\n", key(item), err)
+			continue
+		}
+		cs = append(cs, s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.GracePeriod)
+	defer cancel()
+	_ = c.Out.Reload(ctx, ns, cs)
+}
+
+// sink returns the sink declared by a resource. errs are the errors of the
+// secrets that could not be read.
+func (c *Controller) sink(item resource, cluster bool, errs map[secretKey]error) (*syslog.Sink, error) {
+	spec := item.Spec
+	if spec.Host == "" || spec.Port <= 0 {
+		return nil, errors.New("host and port are required")
+	}
+	s := &syslog.Sink{
+		Name:      "sink/" + key(item),
+		Namespace: item.Metadata.Namespace,
+		Addr:      net.JoinHostPort(spec.Host, strconv.Itoa(spec.Port)),
+		Transport: strings.ToLower(spec.Transport),
+		Framing:   strings.ToLower(spec.Framing),
+		Format:    strings.ToLower(spec.Format),
+	}
+	if cluster {
+		s.Name = "clustersink/" + item.Metadata.Name
+		s.Namespace = ""
+	}
+	if spec.EnableTLS || spec.TLSSecret != "" {
+		s.TLS = &syslog.TLS{
+			InsecureSkipVerify: spec.InsecureSkipVerify,
+			ServerName:         spec.ServerName,
+		}
+	}
+	if spec.TLSSecret != "" {
+		k := c.secretKey(item, cluster)
+		err := errs[k]
+		if err == nil {
+			err = c.writeSecret(k, s.TLS)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tls secret %s/%s: %s", k.namespace, k.name, err)
+		}
+	}
+	return s, s.Validate()
+}
+
+// secretKey returns the key of the TLS secret of a resource.
+func (c *Controller) secretKey(item resource, cluster bool) secretKey {
+	if cluster {
+		return secretKey{c.Namespace, item.Spec.TLSSecret}
+	}
+	return secretKey{item.Metadata.Namespace, item.Spec.TLSSecret}
+}
+
+// loadSecrets reads the given secrets concurrently unless they are cached
+// and resync is false, and drops all other secrets from the cache. It
+// returns the errors of the secrets that could not be read.
+func (c *Controller) loadSecrets(keys map[secretKey]bool, resync bool) map[secretKey]error {
+	type result struct {
+		key secretKey
+		sec secret
+		err error
+	}
+	results := make(chan result)
+	n := 0
+	for k := range keys {
+		if _, ok := c.secrets[k]; ok && !resync {
+			continue
+		}
+		n++
+		go func(k secretKey) {
+			sec, err := c.getSecret(k)
+			results <- result{k, sec, err}
+		}(k)
+	}
+
+	errs := make(map[secretKey]error)
+	for ; n > 0; n-- {
+		r := <-results
+		if r.err != nil {
+			errs[r.key] = r.err
+			delete(c.secrets, r.key)
+			continue
+		}
+		cached, ok := c.secrets[r.key]
+		if !ok {
+			cached = &cachedSecret{}
+			c.secrets[r.key] = cached
+		}
+		cached.secret = r.sec
+	}
+	for k := range c.secrets {
+		if !keys[k] {
+			delete(c.secrets, k)
+		}
+	}
+	return errs
+}
+
+// getSecret reads a secret from the API server.
+func (c *Controller) getSecret(k secretKey) (secret, error) {
+	ctx, cancel := c.requestContext(context.Background())
+	defer cancel()
+	resp, err := c.get(ctx, fmt.Sprintf(
+		"%s/api/v1/namespaces/%s/secrets/%s",
+		c.Host,
+		url.PathEscape(k.namespace),
+		url.PathEscape(k.name),
+	))
+	if err != nil {
+		return secret{}, err
+	}
+	defer resp.Body.Close()
+	var sec secret
+	err = json.NewDecoder(resp.Body).Decode(&sec)
+	return sec, err
+}
+
+// writeSecret writes the certificates of a cached TLS secret to SecretDir
+// and configures t to use them. Files are only replaced if the resource
+// version of the secret changed.
+func (c *Controller) writeSecret(k secretKey, t *syslog.TLS) error {
+	cached := c.secrets[k]
+	dir := filepath.Join(c.SecretDir, k.namespace, k.name)
+	current := cached.written != "" && cached.written == cached.Metadata.ResourceVersion
+	if !current {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+	for name, path := range map[string]*string{
+		"ca.crt":  &t.RootCA,
+		"tls.crt": &t.Cert,
+		"tls.key": &t.Key,
+	} {
+		data, ok := cached.Data[name]
+		if !ok {
+			continue
+		}
+		*path = filepath.Join(dir, name)
+		if current {
+			continue
+		}
+		err := writeFile(*path, data)
+		if err != nil {
+			return err
+		}
+	}
+	cached.written = cached.Metadata.ResourceVersion
+	return nil
+}
+
+// writeFile atomically replaces the file at path unless it already has the
+// given content.
+func writeFile(path string, data []byte) error {
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// requestContext returns the context of a request other than a watch.
+func (c *Controller) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (c *Controller) resourceURL(r string, query url.Values) string {
+	u := fmt.Sprintf("%s/apis/%s/%s/%s", c.Host, Group, Version, r)
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *Controller) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.TokenFile != "" {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+func key(item resource) string {
+	if item.Metadata.Namespace == "" {
+		return item.Metadata.Name
+	}
+	return item.Metadata.Namespace + "/" + item.Metadata.Name
+}
+
+func sorted(items []resource) []resource {
+	sort.Slice(items, func(i, j int) bool {
+		return key(items[i]) < key(items[j])
+	})
+	return items
+}
@@ -0,0 +1,16 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	log.SetOutput(ioutil.Discard)
+	RunSpecs(t, "Controller Suite")
+}
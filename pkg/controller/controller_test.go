@@ -0,0 +1,411 @@
+package controller_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/controller"
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// fakeAPI serves Sink and ClusterSink resources and secrets like the
+// Kubernetes API server does.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	items   map[string][]interface{}
+	events  map[string]chan interface{}
+	secrets map[string]map[string][]byte
+	// hung are the secrets whose requests never complete.
+	hung        map[string]bool
+	secretReads int
+	tokens      []string
+}
+
+func newFakeAPI() *fakeAPI {
+	api := &fakeAPI{
+		items: make(map[string][]interface{}),
+		events: map[string]chan interface{}{
+			"sinks":        make(chan interface{}, 10),
+			"clustersinks": make(chan interface{}, 10),
+		},
+		secrets: make(map[string]map[string][]byte),
+		hung:    make(map[string]bool),
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+func (a *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.tokens = append(a.tokens, r.Header.Get("Authorization"))
+	a.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/") {
+		parts := strings.Split(r.URL.Path, "/")
+		a.mu.Lock()
+		data, ok := a.secrets[parts[4]+"/"+parts[6]]
+		hung := a.hung[parts[4]+"/"+parts[6]]
+		a.secretReads++
+		a.mu.Unlock()
+		if hung {
+			<-r.Context().Done()
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		return
+	}
+
+	resource := strings.TrimPrefix(r.URL.Path, "/apis/apps.pivotal.io/v1beta1/")
+	events, ok := a.events[resource]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		a.mu.Lock()
+		items := a.items[resource]
+		a.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": "1"},
+			"items":    items,
+		})
+		return
+	}
+
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case e := <-events:
+			_ = json.NewEncoder(w).Encode(e)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *fakeAPI) add(resource string, item interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.items[resource] = append(a.items[resource], item)
+}
+
+func (a *fakeAPI) send(resource, eventType string, item interface{}) {
+	a.events[resource] <- map[string]interface{}{
+		"type":   eventType,
+		"object": item,
+	}
+}
+
+func (a *fakeAPI) addSecret(namespace, name string, data map[string][]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secrets[namespace+"/"+name] = data
+}
+
+func (a *fakeAPI) hangSecret(namespace, name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hung[namespace+"/"+name] = true
+}
+
+func (a *fakeAPI) reads() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.secretReads
+}
+
+func (a *fakeAPI) authorizations() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.tokens...)
+}
+
+func item(namespace, name string, spec map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]string{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": spec,
+	}
+}
+
+func target(lis net.Listener) map[string]interface{} {
+	addr := lis.Addr().(*net.TCPAddr)
+	return map[string]interface{}{
+		"host": addr.IP.String(),
+		"port": addr.Port,
+	}
+}
+
+// collector records the messages of all connections to its listener.
+type collector struct {
+	net.Listener
+
+	mu   sync.Mutex
+	msgs []string
+}
+
+func newCollector(lis net.Listener) *collector {
+	c := &collector{Listener: lis}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var msg rfc5424.Message
+					_, err := msg.ReadFrom(r)
+					if err != nil {
+						return
+					}
+					c.mu.Lock()
+					c.msgs = append(c.msgs, string(msg.Message))
+					c.mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return c
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.msgs...)
+}
+
+func listen() net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return lis
+}
+
+var _ = Describe("Controller", func() {
+	var (
+		api       *fakeAPI
+		out       *syslog.Out
+		c         *controller.Controller
+		stop      chan struct{}
+		secretDir string
+		record    func(ns string) map[interface{}]interface{}
+		names     func() []string
+	)
+
+	BeforeEach(func() {
+		api = newFakeAPI()
+		out = syslog.NewOut(nil, nil)
+		var err error
+		secretDir, err = ioutil.TempDir("", "controller")
+		Expect(err).ToNot(HaveOccurred())
+		c = &controller.Controller{
+			Host:          api.URL,
+			Namespace:     "pks-system",
+			SecretDir:     secretDir,
+			Out:           out,
+			GracePeriod:   time.Second,
+			RetryInterval: 10 * time.Millisecond,
+		}
+		stop = make(chan struct{})
+
+		record = func(ns string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte("some-log"),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte(ns),
+				},
+			}
+		}
+		names = func() []string {
+			var names []string
+			for _, s := range out.SinkState() {
+				names = append(names, s.Name)
+			}
+			return names
+		}
+	})
+
+	AfterEach(func() {
+		close(stop)
+		api.Close()
+		_ = os.RemoveAll(secretDir)
+	})
+
+	It("applies the sinks and cluster sinks", func() {
+		ns := newCollector(listen())
+		defer ns.Close()
+		cluster := newCollector(listen())
+		defer cluster.Close()
+		api.add("sinks", item("ns-a", "drain", target(ns)))
+		api.add("clustersinks", item("", "audit", target(cluster)))
+
+		token := filepath.Join(secretDir, "token")
+		Expect(ioutil.WriteFile(token, []byte("some-token\n"), 0600)).To(Succeed())
+		c.TokenFile = token
+		go c.Run(stop)
+
+		Eventually(names).Should(ConsistOf("sink/ns-a/drain", "clustersink/audit"))
+		out.Write(record("ns-a"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("ns-b"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(ns.received).Should(Equal([]string{"some-log\n"}))
+		Eventually(cluster.received).Should(Equal([]string{"some-log\n", "some-log\n"}))
+		Expect(api.authorizations()).To(ContainElement("Bearer some-token"))
+	})
+
+	It("applies changes to the resources", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		static := &syslog.Sink{Name: "static", Addr: lis.Addr().String()}
+		c.ClusterSinks = []*syslog.Sink{static}
+		go c.Run(stop)
+
+		Eventually(names).Should(ConsistOf("static"))
+
+		drain := item("ns-a", "drain", target(lis))
+		api.send("sinks", "ADDED", drain)
+		Eventually(names).Should(ConsistOf("static", "sink/ns-a/drain"))
+
+		api.send("sinks", "DELETED", drain)
+		Eventually(names).Should(ConsistOf("static"))
+		Expect(out.ReloadState().Removed).To(Equal(1))
+	})
+
+	It("skips resources that do not declare a valid sink", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		api.add("sinks", item("ns-a", "no-port", map[string]interface{}{"host": "localhost"}))
+		invalid := target(lis)
+		invalid["transport"] = "carrier-pigeon"
+		api.add("sinks", item("ns-a", "invalid", invalid))
+		api.add("sinks", item("ns-a", "missing-secret", map[string]interface{}{
+			"host":       "localhost",
+			"port":       514,
+			"tls_secret": "missing",
+		}))
+		api.add("sinks", item("ns-a", "valid", target(lis)))
+		go c.Run(stop)
+
+		Eventually(names).Should(ConsistOf("sink/ns-a/valid"))
+		Consistently(names, 100*time.Millisecond).Should(ConsistOf("sink/ns-a/valid"))
+	})
+
+	It("configures TLS from the secret of a sink", func() {
+		cert, err := tls.LoadX509KeyPair("../syslog/testdata/server.crt", "../syslog/testdata/server.key")
+		Expect(err).ToNot(HaveOccurred())
+		ca, err := ioutil.ReadFile("../syslog/testdata/rootCA.crt")
+		Expect(err).ToNot(HaveOccurred())
+		lis := newCollector(tls.NewListener(listen(), &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}))
+		defer lis.Close()
+
+		spec := target(lis)
+		spec["tls_secret"] = "drain-tls"
+		api.add("clustersinks", item("", "audit", spec))
+		api.addSecret("pks-system", "drain-tls", map[string][]byte{"ca.crt": ca})
+		go c.Run(stop)
+
+		Eventually(names).Should(ConsistOf("clustersink/audit"))
+		out.Write(record("ns-a"), time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(lis.received).Should(Equal([]string{"some-log\n"}))
+
+		written, err := ioutil.ReadFile(filepath.Join(secretDir, "pks-system", "drain-tls", "ca.crt"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(written).To(Equal(ca))
+	})
+
+	It("reads the secret of a sink again only on resync", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		ca, err := ioutil.ReadFile("../syslog/testdata/rootCA.crt")
+		Expect(err).ToNot(HaveOccurred())
+		spec := target(lis)
+		spec["tls_secret"] = "drain-tls"
+		api.add("sinks", item("ns-a", "audit", spec))
+		api.addSecret("ns-a", "drain-tls", map[string][]byte{"ca.crt": ca})
+		go c.Run(stop)
+
+		Eventually(names).Should(ConsistOf("sink/ns-a/audit"))
+		api.send("sinks", "ADDED", item("ns-a", "drain", target(lis)))
+		Eventually(names).Should(ConsistOf("sink/ns-a/audit", "sink/ns-a/drain"))
+		api.send("sinks", "DELETED", item("ns-a", "drain", target(lis)))
+		Eventually(names).Should(ConsistOf("sink/ns-a/audit"))
+		Expect(api.reads()).To(Equal(1))
+	})
+
+	It("reads the secrets of all sinks again on resync", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		ca, err := ioutil.ReadFile("../syslog/testdata/rootCA.crt")
+		Expect(err).ToNot(HaveOccurred())
+		spec := target(lis)
+		spec["tls_secret"] = "drain-tls"
+		api.add("sinks", item("ns-a", "audit", spec))
+		api.addSecret("ns-a", "drain-tls", map[string][]byte{"ca.crt": ca})
+		c.ResyncInterval = 50 * time.Millisecond
+		go c.Run(stop)
+
+		Eventually(api.reads).Should(BeNumerically(">", 2))
+		Expect(names()).To(ConsistOf("sink/ns-a/audit"))
+	})
+
+	It("skips sinks whose secret can not be read in time", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		for _, name := range []string{"hung-1", "hung-2", "hung-3", "hung-4"} {
+			spec := target(lis)
+			spec["tls_secret"] = name
+			api.add("sinks", item("ns-a", name, spec))
+			api.hangSecret("ns-a", name)
+		}
+		api.add("sinks", item("ns-a", "valid", target(lis)))
+		c.RequestTimeout = 300 * time.Millisecond
+		go c.Run(stop)
+
+		// The secrets are read concurrently.
+		Eventually(names, 900*time.Millisecond).Should(ConsistOf("sink/ns-a/valid"))
+	})
+
+	It("lists the resources again right away when the resource version expired", func() {
+		lis := newCollector(listen())
+		defer lis.Close()
+		c.RetryInterval = time.Hour
+		go c.Run(stop)
+		Eventually(api.authorizations).Should(HaveLen(4))
+
+		api.add("sinks", item("ns-a", "drain", target(lis)))
+		api.send("sinks", "ERROR", map[string]interface{}{
+			"kind":    "Status",
+			"code":    410,
+			"message": "too old resource version: 1",
+		})
+
+		Eventually(names).Should(ConsistOf("sink/ns-a/drain"))
+	})
+})
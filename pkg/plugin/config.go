@@ -0,0 +1,441 @@
+// Package plugin parses the configuration keys of a syslog output plugin
+// instance.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+const (
+	// DefaultGracePeriod is how long a plugin instance sends the messages
+	// left in its queues when fluent-bit exits.
+	DefaultGracePeriod = 5 * time.Second
+
+	// DefaultSinksFileReloadInterval is how often the SinksFile is
+	// checked for changes.
+	DefaultSinksFileReloadInterval = 10 * time.Second
+)
+
+// Keys are the configuration keys of the plugin in lower case. Fluent-bit
+// matches keys case-insensitively.
+var Keys = []string{
+	"addr",
+	"backpressure",
+	"batchbytes",
+	"batchlinger",
+	"buffersize",
+	"cluster",
+	"dialtimeout",
+	"discovery",
+	"diskqueue",
+	"ejectionduration",
+	"failbackinterval",
+	"failoveraddrs",
+	"format",
+	"framing",
+	"graceperiod",
+	"httpbatchsize",
+	"instancename",
+	"loadbalancing",
+	"maxdatagramsize",
+	"maxreconnectbackoff",
+	"namespace",
+	"proxy",
+	"reconnectbackoff",
+	"relpwindowsize",
+	"required",
+	"resolveinterval",
+	"retryattempts",
+	"retrymaxage",
+	"sanitizehost",
+	"shardbypod",
+	"sinkresources",
+	"sinksfile",
+	"sinksfilereloadinterval",
+	"tlsconfig",
+	"tlsreloadinterval",
+	"transport",
+	"workers",
+	"writetimeout",
+}
+
+// Config is the configuration of a plugin instance.
+type Config struct {
+	Name string
+	// Sink is the sink declared by Addr, if any.
+	Sink *syslog.Sink
+	// Cluster reports whether Sink is a cluster sink.
+	Cluster bool
+	// SinksFile loads the sinks declared in the SinksFile. It is nil
+	// without a SinksFile. Its Sinks and ClusterSinks hold Sink.
+	SinksFile *syslog.SinksFileWatcher
+	// FileSinks and FileClusterSinks are the sinks declared in the
+	// SinksFile.
+	FileSinks               []*syslog.Sink
+	FileClusterSinks        []*syslog.Sink
+	SinksFileReloadInterval time.Duration
+	SinkResources           bool
+	GracePeriod             time.Duration
+	Options                 []syslog.OutOption
+}
+
+// Sinks returns all sinks the plugin instance starts with.
+func (c *Config) Sinks() (sinks, clusterSinks []*syslog.Sink) {
+	sinks = append(sinks, c.FileSinks...)
+	clusterSinks = append(clusterSinks, c.FileClusterSinks...)
+	if c.Sink == nil {
+		return sinks, clusterSinks
+	}
+	if c.Cluster {
+		return sinks, append(clusterSinks, c.Sink)
+	}
+	return append(sinks, c.Sink), clusterSinks
+}
+
+// Parse parses the configuration of a plugin instance. key returns the
+// value of the given key or an empty string if the key is not set.
+func Parse(key func(string) string) (*Config, error) {
+	addr := key("addr")
+	sinksFile := key("sinksfile")
+	sinksFileReloadInterval := key("sinksfilereloadinterval")
+	sinkResources := key("sinkresources")
+	name := key("instancename")
+	namespace := key("namespace")
+	cluster := key("cluster")
+	tls := key("tlsconfig")
+	diskQueue := key("diskqueue")
+	sanitizeHost := key("sanitizehost")
+	backpressure := key("backpressure")
+	gracePeriod := key("graceperiod")
+	transport := strings.ToLower(key("transport"))
+	framing := strings.ToLower(key("framing"))
+	format := strings.ToLower(key("format"))
+	proxy := key("proxy")
+	failoverAddrs := key("failoveraddrs")
+	failbackInterval := key("failbackinterval")
+	discovery := strings.ToLower(key("discovery"))
+	resolveInterval := key("resolveinterval")
+	loadBalancing := strings.ToLower(key("loadbalancing"))
+	ejectionDuration := key("ejectionduration")
+	reconnectBackoff := key("reconnectbackoff")
+	maxReconnectBackoff := key("maxreconnectbackoff")
+	retryAttempts := key("retryattempts")
+	retryMaxAge := key("retrymaxage")
+	workers := key("workers")
+	shardByPod := key("shardbypod")
+	required := key("required")
+	maxDatagramSize := key("maxdatagramsize")
+	relpWindowSize := key("relpwindowsize")
+	tlsReloadInterval := key("tlsreloadinterval")
+	httpBatchSize := key("httpbatchsize")
+	batchBytes := key("batchbytes")
+	batchLinger := key("batchlinger")
+	dialTimeout := key("dialtimeout")
+	writeTimeout := key("writetimeout")
+	bufferSize := key("buffersize")
+
+	c := &Config{
+		Name:                    name,
+		GracePeriod:             DefaultGracePeriod,
+		SinksFileReloadInterval: DefaultSinksFileReloadInterval,
+	}
+
+	if len(sinkResources) != 0 {
+		b, err := strconv.ParseBool(sinkResources)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SinkResources: %s", err)
+		}
+		c.SinkResources = b
+	}
+	if addr == "" && sinksFile == "" && !c.SinkResources {
+		return nil, errors.New("Addr, SinksFile or SinkResources is required")
+	}
+	if sinksFile != "" && c.SinkResources {
+		return nil, errors.New("SinksFile and SinkResources can not be used together")
+	}
+	if name == "" {
+		return nil, errors.New("InstanceName is required")
+	}
+
+	var err error
+	if sinksFile != "" {
+		c.SinksFile = &syslog.SinksFileWatcher{
+			Path: sinksFile,
+		}
+		c.FileSinks, c.FileClusterSinks, err = c.SinksFile.Load()
+		if err != nil {
+			return nil, fmt.Errorf("invalid SinksFile %s: %s", sinksFile, err)
+		}
+	}
+
+	if addr != "" {
+		for _, s := range append(c.FileSinks, c.FileClusterSinks...) {
+			if s.Name == name {
+				return nil, fmt.Errorf("SinksFile %s declares a sink named after InstanceName %s", sinksFile, name)
+			}
+		}
+		sink := &syslog.Sink{
+			Addr:          addr,
+			Name:          name,
+			Namespace:     namespace,
+			Transport:     transport,
+			Framing:       framing,
+			Format:        format,
+			Proxy:         proxy,
+			Discovery:     discovery,
+			LoadBalancing: loadBalancing,
+		}
+		if len(workers) != 0 {
+			n, err := parsePositiveInt(workers)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse Workers: %s", err)
+			}
+			sink.Workers = n
+		}
+		if len(shardByPod) != 0 {
+			shard, err := strconv.ParseBool(shardByPod)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse ShardByPod: %s", err)
+			}
+			sink.ShardByPod = shard
+		}
+		if len(required) != 0 {
+			r, err := strconv.ParseBool(required)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse Required: %s", err)
+			}
+			sink.Required = r
+		}
+		for _, a := range strings.Split(failoverAddrs, ",") {
+			a = strings.TrimSpace(a)
+			if a != "" {
+				sink.FailoverAddrs = append(sink.FailoverAddrs, a)
+			}
+		}
+		if tls != "" {
+			var tlsConfig syslog.TLS
+			err := json.Unmarshal([]byte(tls), &tlsConfig)
+			if err != nil {
+				return nil, fmt.Errorf("unable to unmarshal TLS config: %s", err)
+			}
+			sink.TLS = &tlsConfig
+		}
+		if diskQueue != "" {
+			var diskQueueConfig syslog.DiskQueue
+			err := json.Unmarshal([]byte(diskQueue), &diskQueueConfig)
+			if err != nil {
+				return nil, fmt.Errorf("unable to unmarshal DiskQueue config: %s", err)
+			}
+			sink.DiskQueue = &diskQueueConfig
+			for _, s := range append(c.FileSinks, c.FileClusterSinks...) {
+				if s.DiskQueue != nil && filepath.Clean(s.DiskQueue.Dir) == filepath.Clean(diskQueueConfig.Dir) {
+					return nil, fmt.Errorf("SinksFile %s declares a sink with the disk queue dir of InstanceName %s", sinksFile, name)
+				}
+			}
+		}
+		err = sink.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid sink configuration: %s", err)
+		}
+		c.Sink = sink
+		c.Cluster = strings.ToLower(cluster) == "true"
+		if c.SinksFile != nil {
+			if c.Cluster {
+				c.SinksFile.ClusterSinks = []*syslog.Sink{sink}
+			} else {
+				c.SinksFile.Sinks = []*syslog.Sink{sink}
+			}
+		}
+	}
+
+	// Defaults to true so that plugin conforms better with rfc5424#section-6.2.4
+	sanitize := true
+	if len(sanitizeHost) != 0 {
+		sanitize, err = strconv.ParseBool(sanitizeHost)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SanitizeHost: %s", err)
+		}
+	}
+	opts := []syslog.OutOption{
+		syslog.WithSanitizeHost(sanitize),
+	}
+	if len(backpressure) != 0 {
+		b, err := strconv.ParseBool(backpressure)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse Backpressure: %s", err)
+		}
+		opts = append(opts, syslog.WithBackpressure(b))
+	}
+	if len(dialTimeout) != 0 {
+		d, err := parsePositiveDuration(dialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse DialTimeout: %s", err)
+		}
+		opts = append(opts, syslog.WithDialTimeout(d))
+	}
+	if len(writeTimeout) != 0 {
+		d, err := parsePositiveDuration(writeTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse WriteTimeout: %s", err)
+		}
+		opts = append(opts, syslog.WithWriteTimeout(d))
+	}
+	if len(bufferSize) != 0 {
+		size, err := parsePositiveInt(bufferSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse BufferSize: %s", err)
+		}
+		opts = append(opts, syslog.WithBufferSize(size))
+	}
+	if len(maxDatagramSize) != 0 {
+		size, err := parsePositiveInt(maxDatagramSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse MaxDatagramSize: %s", err)
+		}
+		opts = append(opts, syslog.WithMaxDatagramSize(size))
+	}
+	if len(relpWindowSize) != 0 {
+		size, err := parsePositiveInt(relpWindowSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse RELPWindowSize: %s", err)
+		}
+		opts = append(opts, syslog.WithRELPWindowSize(size))
+	}
+	if len(httpBatchSize) != 0 {
+		size, err := parsePositiveInt(httpBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse HTTPBatchSize: %s", err)
+		}
+		opts = append(opts, syslog.WithHTTPBatchSize(size))
+	}
+	if len(batchBytes) != 0 {
+		size, err := parsePositiveInt(batchBytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse BatchBytes: %s", err)
+		}
+		var linger time.Duration
+		if len(batchLinger) != 0 {
+			linger, err = time.ParseDuration(batchLinger)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse BatchLinger: %s", err)
+			}
+		}
+		opts = append(opts, syslog.WithWriteBatch(size, linger))
+	}
+	if len(tlsReloadInterval) != 0 {
+		d, err := time.ParseDuration(tlsReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse TLSReloadInterval: %s", err)
+		}
+		opts = append(opts, syslog.WithTLSReloadInterval(d))
+	}
+	if len(failbackInterval) != 0 {
+		d, err := time.ParseDuration(failbackInterval)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse FailbackInterval: %s", err)
+		}
+		opts = append(opts, syslog.WithFailbackInterval(d))
+	}
+	if len(resolveInterval) != 0 {
+		d, err := time.ParseDuration(resolveInterval)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ResolveInterval: %s", err)
+		}
+		opts = append(opts, syslog.WithResolveInterval(d))
+	}
+	if len(ejectionDuration) != 0 {
+		d, err := time.ParseDuration(ejectionDuration)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse EjectionDuration: %s", err)
+		}
+		opts = append(opts, syslog.WithEjectionDuration(d))
+	}
+	if len(reconnectBackoff) != 0 || len(maxReconnectBackoff) != 0 {
+		min, max := 500*time.Millisecond, 30*time.Second
+		if len(reconnectBackoff) != 0 {
+			// Zero disables the backoff.
+			d, err := time.ParseDuration(reconnectBackoff)
+			if err == nil && d != 0 {
+				d, err = parsePositiveDuration(reconnectBackoff)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse ReconnectBackoff: %s", err)
+			}
+			min = d
+		}
+		if len(maxReconnectBackoff) != 0 {
+			d, err := parsePositiveDuration(maxReconnectBackoff)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse MaxReconnectBackoff: %s", err)
+			}
+			max = d
+		}
+		if min > max {
+			return nil, fmt.Errorf("ReconnectBackoff %s is greater than MaxReconnectBackoff %s", min, max)
+		}
+		opts = append(opts, syslog.WithReconnectBackoff(min, max))
+	}
+	if len(retryAttempts) != 0 || len(retryMaxAge) != 0 {
+		attempts, maxAge := 3, 10*time.Second
+		if len(retryAttempts) != 0 {
+			n, err := strconv.Atoi(retryAttempts)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("unable to parse RetryAttempts: %q is not a non-negative number", retryAttempts)
+			}
+			attempts = n
+		}
+		if len(retryMaxAge) != 0 {
+			d, err := time.ParseDuration(retryMaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse RetryMaxAge: %s", err)
+			}
+			maxAge = d
+		}
+		opts = append(opts, syslog.WithRetry(attempts, maxAge))
+	}
+	c.Options = opts
+
+	if len(gracePeriod) != 0 {
+		c.GracePeriod, err = time.ParseDuration(gracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse GracePeriod: %s", err)
+		}
+	}
+	if len(sinksFileReloadInterval) != 0 {
+		c.SinksFileReloadInterval, err = time.ParseDuration(sinksFileReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SinksFileReloadInterval: %s", err)
+		}
+	}
+	return c, nil
+}
+
+func parsePositiveInt(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if i <= 0 {
+		return 0, fmt.Errorf("%d is not a positive number", i)
+	}
+	return i, nil
+}
+
+func parsePositiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s is not a positive duration", d)
+	}
+	return d, nil
+}
@@ -0,0 +1,178 @@
+package plugin_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/plugin"
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// keys returns the value of the given key like FLBPluginConfigKey does.
+func keys(kv map[string]string) func(string) string {
+	return func(key string) string {
+		for k, v := range kv {
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+		return ""
+	}
+}
+
+var _ = Describe("Parse", func() {
+	It("parses the sink declared by Addr", func() {
+		cfg, err := plugin.Parse(keys(map[string]string{
+			"InstanceName":  "tenant-a",
+			"Addr":          "logs.example.com:6514",
+			"Namespace":     "tenant-a",
+			"Transport":     "TCP",
+			"FailoverAddrs": "backup-1.example.com:6514, backup-2.example.com:6514",
+			"TLSConfig":     `{"insecure_skip_verify": true}`,
+			"Workers":       "2",
+			"Required":      "true",
+			"GracePeriod":   "1s",
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.Name).To(Equal("tenant-a"))
+		Expect(cfg.Cluster).To(BeFalse())
+		Expect(cfg.Sink).To(Equal(&syslog.Sink{
+			Name:          "tenant-a",
+			Addr:          "logs.example.com:6514",
+			Namespace:     "tenant-a",
+			Transport:     "tcp",
+			FailoverAddrs: []string{"backup-1.example.com:6514", "backup-2.example.com:6514"},
+			TLS:           &syslog.TLS{InsecureSkipVerify: true},
+			Workers:       2,
+			Required:      true,
+		}))
+		Expect(cfg.GracePeriod).To(Equal(time.Second))
+		Expect(cfg.SinksFile).To(BeNil())
+
+		sinks, clusterSinks := cfg.Sinks()
+		Expect(sinks).To(Equal([]*syslog.Sink{cfg.Sink}))
+		Expect(clusterSinks).To(BeEmpty())
+	})
+
+	It("combines the SinksFile with the sink declared by Addr", func() {
+		dir, err := ioutil.TempDir("", "plugin")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "sinks.yml")
+		Expect(ioutil.WriteFile(path, []byte(`
+sinks:
+- name: tenant-a
+  namespace: tenant-a
+  addr: localhost:514
+`), 0600)).To(Succeed())
+
+		cfg, err := plugin.Parse(keys(map[string]string{
+			"InstanceName": "audit",
+			"Addr":         "localhost:1514",
+			"Cluster":      "true",
+			"SinksFile":    path,
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.FileSinks).To(HaveLen(1))
+		Expect(cfg.SinksFile.ClusterSinks).To(Equal([]*syslog.Sink{cfg.Sink}))
+		Expect(cfg.SinksFileReloadInterval).To(Equal(plugin.DefaultSinksFileReloadInterval))
+
+		sinks, clusterSinks := cfg.Sinks()
+		Expect(sinks).To(Equal(cfg.FileSinks))
+		Expect(clusterSinks).To(Equal([]*syslog.Sink{cfg.Sink}))
+	})
+
+	It("reports a SinksFile that shares the disk queue dir of the sink declared by Addr", func() {
+		dir, err := ioutil.TempDir("", "plugin")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "sinks.yml")
+		Expect(ioutil.WriteFile(path, []byte(`
+sinks:
+- name: tenant-a
+  namespace: tenant-a
+  addr: localhost:514
+  disk_queue:
+    dir: /var/spool/out-syslog
+`), 0600)).To(Succeed())
+
+		_, err = plugin.Parse(keys(map[string]string{
+			"InstanceName": "audit",
+			"Addr":         "localhost:1514",
+			"Cluster":      "true",
+			"SinksFile":    path,
+			"DiskQueue":    `{"dir": "/var/spool/out-syslog/"}`,
+		}))
+		Expect(err).To(MatchError(ContainSubstring("declares a sink with the disk queue dir of InstanceName audit")))
+	})
+
+	DescribeTable("reports invalid configurations", func(kv map[string]string, msg string) {
+		_, err := plugin.Parse(keys(kv))
+		Expect(err).To(MatchError(ContainSubstring(msg)))
+	},
+		Entry("no sinks", map[string]string{
+			"InstanceName": "a",
+		}, "Addr, SinksFile or SinkResources is required"),
+		Entry("SinksFile and SinkResources", map[string]string{
+			"InstanceName":  "a",
+			"SinksFile":     "sinks.yml",
+			"SinkResources": "true",
+		}, "SinksFile and SinkResources can not be used together"),
+		Entry("no InstanceName", map[string]string{
+			"Addr": "localhost:514",
+		}, "InstanceName is required"),
+		Entry("missing SinksFile", map[string]string{
+			"InstanceName": "a",
+			"SinksFile":    "/does/not/exist.yml",
+		}, "invalid SinksFile /does/not/exist.yml"),
+		Entry("invalid sink", map[string]string{
+			"InstanceName": "a",
+			"Addr":         "localhost:514",
+			"Transport":    "carrier-pigeon",
+		}, "invalid sink configuration: unsupported transport"),
+		Entry("invalid TLSConfig", map[string]string{
+			"InstanceName": "a",
+			"Addr":         "localhost:514",
+			"TLSConfig":    "{",
+		}, "unable to unmarshal TLS config"),
+		Entry("invalid BufferSize", map[string]string{
+			"InstanceName": "a",
+			"Addr":         "localhost:514",
+			"BufferSize":   "0",
+		}, "unable to parse BufferSize: 0 is not a positive number"),
+		Entry("invalid DialTimeout", map[string]string{
+			"InstanceName": "a",
+			"Addr":         "localhost:514",
+			"DialTimeout":  "-1s",
+		}, "unable to parse DialTimeout: -1s is not a positive duration"),
+		Entry("negative MaxReconnectBackoff", map[string]string{
+			"InstanceName":        "a",
+			"Addr":                "localhost:514",
+			"MaxReconnectBackoff": "-1s",
+		}, "unable to parse MaxReconnectBackoff: -1s is not a positive duration"),
+		Entry("ReconnectBackoff greater than MaxReconnectBackoff", map[string]string{
+			"InstanceName":     "a",
+			"Addr":             "localhost:514",
+			"ReconnectBackoff": "1m",
+		}, "ReconnectBackoff 1m0s is greater than MaxReconnectBackoff 30s"),
+		Entry("invalid RetryAttempts", map[string]string{
+			"InstanceName":  "a",
+			"Addr":          "localhost:514",
+			"RetryAttempts": "-1",
+		}, `unable to parse RetryAttempts: "-1" is not a non-negative number`),
+		Entry("invalid Required", map[string]string{
+			"InstanceName": "a",
+			"Addr":         "localhost:514",
+			"Required":     "sometimes",
+		}, "unable to parse Required"),
+	)
+})
@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// genericKeys are the keys fluent-bit handles itself for every output
+// plugin.
+var genericKeys = map[string]bool{
+	"name":                  true,
+	"match":                 true,
+	"match_regex":           true,
+	"alias":                 true,
+	"log_level":             true,
+	"log_suppress_interval": true,
+	"retry_limit":           true,
+	"host":                  true,
+	"port":                  true,
+	"ipv6":                  true,
+	"tls":                   true,
+	"upstream":              true,
+}
+
+// genericPrefixes are the prefixes of the keys fluent-bit handles itself
+// for every output plugin, e.g. tls.verify or storage.total_limit_size.
+var genericPrefixes = []string{
+	"net.",
+	"storage.",
+	"tls.",
+}
+
+var variable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Entry is a key and its value in a section of a fluent-bit configuration
+// file.
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Section is a section of a fluent-bit configuration file such as
+// [SERVICE] or [OUTPUT].
+type Section struct {
+	Name    string
+	File    string
+	Line    int
+	Entries []Entry
+}
+
+// Get returns the value of the key or an empty string if the key is not
+// set. Keys are matched case-insensitively like fluent-bit does.
+func (s *Section) Get(key string) string {
+	for _, e := range s.Entries {
+		if strings.EqualFold(e.Key, key) {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+// IsSyslogOutput reports whether the section configures an instance of
+// the syslog output plugin.
+func (s *Section) IsSyslogOutput() bool {
+	return strings.EqualFold(s.Name, "OUTPUT") && strings.EqualFold(s.Get("name"), "syslog")
+}
+
+// UnknownKeys returns the keys of the section that are neither keys of the
+// plugin nor keys fluent-bit handles itself.
+func (s *Section) UnknownKeys() []string {
+	known := make(map[string]bool, len(Keys))
+	for _, k := range Keys {
+		known[k] = true
+	}
+
+	var unknown []string
+	for _, e := range s.Entries {
+		k := strings.ToLower(e.Key)
+		if !known[k] && !generic(k) {
+			unknown = append(unknown, e.Key)
+		}
+	}
+	return unknown
+}
+
+// generic reports whether fluent-bit handles the lower case key itself.
+func generic(k string) bool {
+	if genericKeys[k] {
+		return true
+	}
+	for _, p := range genericPrefixes {
+		if strings.HasPrefix(k, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFluentBitConfig reads the sections of a fluent-bit configuration
+// file in the classic format. @INCLUDE directives are followed relative to
+// the including file and ${VAR} references are replaced with variables
+// declared by @SET or the environment.
+func LoadFluentBitConfig(path string) ([]*Section, error) {
+	l := &loader{
+		vars:    make(map[string]string),
+		loading: make(map[string]bool),
+	}
+	err := l.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.sections, nil
+}
+
+type loader struct {
+	vars     map[string]string
+	loading  map[string]bool
+	sections []*Section
+}
+
+func (l *loader) load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.loading[abs] {
+		return fmt.Errorf("%s includes itself", path)
+	}
+	l.loading[abs] = true
+	defer delete(l.loading, abs)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var current *Section
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%s:%d: invalid section %s", path, n, line)
+			}
+			current = &Section{
+				Name: strings.TrimSpace(line[1 : len(line)-1]),
+				File: path,
+				Line: n,
+			}
+			l.sections = append(l.sections, current)
+			continue
+		case strings.HasPrefix(line, "@"):
+			err := l.directive(path, n, line)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if current == nil {
+			return fmt.Errorf("%s:%d: %s is not in a section", path, n, line)
+		}
+		key, value := splitEntry(line)
+		if value == "" {
+			return fmt.Errorf("%s:%d: key %s has no value", path, n, key)
+		}
+		current.Entries = append(current.Entries, Entry{
+			Key:   key,
+			Value: l.expand(value),
+			Line:  n,
+		})
+	}
+	return scanner.Err()
+}
+
+func (l *loader) directive(path string, n int, line string) error {
+	name, arg := splitEntry(line)
+	switch strings.ToUpper(name) {
+	case "@INCLUDE":
+		pattern := arg
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s:%d: %s does not match any files", path, n, arg)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			err = l.load(m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "@SET":
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return fmt.Errorf("%s:%d: @SET requires KEY=VALUE", path, n)
+		}
+		l.vars[strings.TrimSpace(kv[0])] = l.expand(strings.TrimSpace(kv[1]))
+		return nil
+	}
+	return fmt.Errorf("%s:%d: unknown directive %s", path, n, name)
+}
+
+// splitEntry splits a line into its key and the value after the first
+// whitespace.
+func splitEntry(line string) (string, string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// expand replaces ${VAR} references in the value.
+func (l *loader) expand(value string) string {
+	return variable.ReplaceAllStringFunc(value, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if v, ok := l.vars[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+}
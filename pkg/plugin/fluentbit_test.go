@@ -0,0 +1,112 @@
+package plugin_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/plugin"
+)
+
+var _ = Describe("LoadFluentBitConfig", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "fluent-bit-config")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		ExpectWithOffset(1, os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+		ExpectWithOffset(1, ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("reads the sections of the file and its includes", func() {
+		Expect(os.Setenv("FLUENT_BIT_CONFIG_TEST_NS", "tenant-a")).To(Succeed())
+		defer os.Unsetenv("FLUENT_BIT_CONFIG_TEST_NS")
+
+		write("outputs/tenant-a.conf", `
+[OUTPUT]
+    Name          syslog
+    Match         *
+    InstanceName  tenant-a
+    Addr          ${COLLECTOR}:514
+	Namespace     ${FLUENT_BIT_CONFIG_TEST_NS}
+`)
+		path := write("fluent-bit.conf", `
+# the collector of all tenants
+@SET COLLECTOR=logs.example.com
+
+[SERVICE]
+    Flush  1
+
+@INCLUDE outputs/*.conf
+
+[OUTPUT]
+    Name   stdout
+    Match  *
+`)
+
+		sections, err := plugin.LoadFluentBitConfig(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sections).To(HaveLen(3))
+
+		s := sections[1]
+		Expect(s.Name).To(Equal("OUTPUT"))
+		Expect(s.File).To(Equal(filepath.Join(dir, "outputs/tenant-a.conf")))
+		Expect(s.Line).To(Equal(2))
+		Expect(s.IsSyslogOutput()).To(BeTrue())
+		Expect(s.Get("addr")).To(Equal("logs.example.com:514"))
+		Expect(s.Get("NAMESPACE")).To(Equal("tenant-a"))
+		Expect(s.UnknownKeys()).To(BeEmpty())
+
+		Expect(sections[0].IsSyslogOutput()).To(BeFalse())
+		Expect(sections[2].IsSyslogOutput()).To(BeFalse())
+	})
+
+	It("reports keys the plugin does not know", func() {
+		path := write("fluent-bit.conf", `
+[OUTPUT]
+    Name          syslog
+    Match         *
+    Retry_Limit   False
+    tls           on
+    tls.verify    off
+    storage.total_limit_size  1G
+    net.keepalive on
+    InstanceName  tenant-a
+    Adr           localhost:514
+`)
+
+		sections, err := plugin.LoadFluentBitConfig(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sections[0].UnknownKeys()).To(Equal([]string{"Adr"}))
+	})
+
+	It("reports invalid files", func() {
+		path := write("fluent-bit.conf", `
+[OUTPUT]
+    Name
+`)
+		_, err := plugin.LoadFluentBitConfig(path)
+		Expect(err).To(MatchError(path + ":3: key Name has no value"))
+
+		path = write("fluent-bit.conf", `@INCLUDE fluent-bit.conf`)
+		_, err = plugin.LoadFluentBitConfig(path)
+		Expect(err).To(MatchError(ContainSubstring("includes itself")))
+
+		path = write("fluent-bit.conf", `@INCLUDE missing/*.conf`)
+		_, err = plugin.LoadFluentBitConfig(path)
+		Expect(err).To(MatchError(ContainSubstring("missing/*.conf does not match any files")))
+	})
+})
@@ -0,0 +1,16 @@
+package plugin_test
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlugin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	log.SetOutput(ioutil.Discard)
+	RunSpecs(t, "Plugin Suite")
+}
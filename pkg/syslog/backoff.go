@@ -0,0 +1,45 @@
+package syslog
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// inBackoff reports whether the sink failed to connect recently and must
+// not try again before its next retry time.
+func (s *Sink) inBackoff() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&s.nextRetryNanos)
+}
+
+// backoff records a failed connection attempt and schedules the next one.
+// The delay doubles with every consecutive failure up to the maximum
+// backoff. Half of the delay is randomized so that many sinks failing at
+// the same time do not reconnect at the same time.
+func (s *Sink) backoff() {
+	failures := atomic.AddInt64(&s.consecutiveFailures, 1)
+	if s.minReconnectBackoff <= 0 {
+		return
+	}
+
+	d := s.maxReconnectBackoff
+	if failures <= 32 {
+		exp := s.minReconnectBackoff << uint(failures-1)
+		if exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	// A maximum below the minimum does not shorten the wait.
+	if d < s.minReconnectBackoff {
+		d = s.minReconnectBackoff
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	atomic.StoreInt64(&s.nextRetryNanos, time.Now().Add(d).UnixNano())
+}
+
+// resetBackoff records a successful connection attempt.
+func (s *Sink) resetBackoff() {
+	atomic.StoreInt64(&s.consecutiveFailures, 0)
+	atomic.StoreInt64(&s.nextRetryNanos, 0)
+}
@@ -0,0 +1,141 @@
+package syslog_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Reconnect backoff", func() {
+	var record map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log-message"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("some-ns"),
+				"pod_name":       []byte("some-pod"),
+			},
+		}
+	})
+
+	It("drops messages without dialing while it waits to reconnect", func() {
+		c := newCollector()
+		addr := c.url()
+		c.stop()
+
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithReconnectBackoff(time.Hour, time.Hour),
+		)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		state := out.SinkState()[0]
+		Expect(state.ConsecutiveFailures).To(Equal(int64(1)))
+		Expect(state.NextRetry).ToNot(BeNil())
+		Expect(*state.NextRetry).To(BeTemporally(">", time.Now().Add(29*time.Minute)))
+		Expect(*state.NextRetry).To(BeTemporally("<=", time.Now().Add(time.Hour)))
+
+		c = newCollector(addr)
+		defer c.stop()
+		for i := 0; i < 10; i++ {
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(11)))
+		Consistently(c.received, 100*time.Millisecond).Should(BeEmpty())
+		Expect(out.SinkState()[0].ConsecutiveFailures).To(Equal(int64(1)))
+	})
+
+	It("increases the wait with every failure and resets it once connected", func() {
+		c := newCollector()
+		addr := c.url()
+		c.stop()
+
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithReconnectBackoff(20*time.Millisecond, 40*time.Millisecond),
+		)
+
+		for i := 1; i <= 3; i++ {
+			waitForRetry(out)
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+			Eventually(func() int64 {
+				return out.SinkState()[0].ConsecutiveFailures
+			}).Should(Equal(int64(i)))
+		}
+
+		c = newCollector(addr)
+		defer c.stop()
+		waitForRetry(out)
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(c.received).Should(Equal([]string{"some-log-message\n"}))
+		state := out.SinkState()[0]
+		Expect(state.ConsecutiveFailures).To(BeZero())
+		Expect(state.NextRetry).To(BeNil())
+		Expect(state.Error).To(BeNil())
+	})
+
+	It("waits for the minimum backoff when the maximum is lower", func() {
+		c := newCollector()
+		addr := c.url()
+		c.stop()
+
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithReconnectBackoff(time.Hour, -time.Second),
+		)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		state := out.SinkState()[0]
+		Expect(state.NextRetry).ToNot(BeNil())
+		Expect(*state.NextRetry).To(BeTemporally(">", time.Now().Add(29*time.Minute)))
+	})
+
+	It("dials for every message when the backoff is disabled", func() {
+		c := newCollector()
+		addr := c.url()
+		c.stop()
+
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithReconnectBackoff(0, 0),
+		)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].NextRetry).To(BeNil())
+
+		c = newCollector(addr)
+		defer c.stop()
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(c.received).Should(Equal([]string{"some-log-message\n"}))
+	})
+})
@@ -0,0 +1,119 @@
+package syslog
+
+import (
+	"io"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+)
+
+// WithBackpressure configures whether Write refuses messages that can not
+// be queued by all of their required sinks, so that they can be written
+// again later, instead of dropping them.
+func WithBackpressure(b bool) OutOption {
+	return func(o *Out) {
+		o.backpressure = b
+	}
+}
+
+// Backpressure reports whether Write refuses messages instead of dropping
+// them.
+func (o *Out) Backpressure() bool {
+	return o.backpressure
+}
+
+// accepting reports whether all required sinks can queue msg.
+func accepting(sinks []*Sink, msg io.WriterTo) bool {
+	for _, s := range sinks {
+		if s.Required && !s.accepting(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// accepting reports whether the sink can queue msg. A sink that waits to
+// reconnect is down and does not accept messages unless they can be kept
+// in its disk queue.
+func (s *Sink) accepting(msg io.WriterTo) bool {
+	if s.diskQueue != nil {
+		return s.diskQueue.accepting()
+	}
+	if s.down() {
+		return false
+	}
+	q := s.queue(msg)
+	return len(q) < cap(q)
+}
+
+// acceptingAll reports whether the sink can queue all of msgs. More
+// messages than fit into the sink's queue are accepted once it is empty.
+func (s *Sink) acceptingAll(msgs []io.WriterTo) bool {
+	if s.diskQueue != nil {
+		var n int64
+		for _, msg := range msgs {
+			m, ok := msg.(*rfc5424.Message)
+			if !ok {
+				continue
+			}
+			data, err := m.MarshalBinary()
+			if err != nil {
+				continue
+			}
+			n += diskRecordHeaderSize + int64(len(data))
+		}
+		return s.diskQueue.room(n)
+	}
+	if s.down() {
+		return false
+	}
+	queued := make(map[chan io.WriterTo]int)
+	for _, msg := range msgs {
+		queued[s.queue(msg)]++
+	}
+	for q, n := range queued {
+		if len(q)+n > cap(q) && (n <= cap(q) || len(q) != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// queueAccepted adds msg, which acceptingAll accepted, to the sink's queue.
+// If the queue is full because the chunk of msg is larger than the queue
+// it waits up to the sink's write timeout for the sink to make room.
+func (s *Sink) queueAccepted(msg io.WriterTo) bool {
+	if s.diskQueue != nil {
+		return s.spool(msg)
+	}
+	q := s.queue(msg)
+	select {
+	case q <- msg:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(s.writeTimeout)
+	defer timer.Stop()
+	select {
+	case q <- msg:
+		return true
+	case <-timer.C:
+		s.dropQueued()
+		return false
+	}
+}
+
+// down reports whether the sink, or every one of its workers, waits to
+// reconnect.
+func (s *Sink) down() bool {
+	if len(s.workers) == 0 {
+		return s.inBackoff()
+	}
+	for _, w := range s.workers {
+		if !w.inBackoff() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package syslog_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Backpressure", func() {
+	var record map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log-message"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("some-ns"),
+			},
+		}
+	})
+
+	// blocked returns a listener that never completes a TLS handshake,
+	// which blocks a TLS sink until its dial timeout.
+	blocked := func() net.Listener {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return lis
+	}
+
+	// unreachable returns an address nothing listens on.
+	unreachable := func() string {
+		c := newCollector()
+		c.stop()
+		return c.url()
+	}
+
+	It("reports whether messages were queued", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			Required:  true,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithBackpressure(true))
+
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+		Eventually(c.received).Should(HaveLen(1))
+	})
+
+	It("refuses messages without dropping them when the queue is full", func() {
+		lis := blocked()
+		defer lis.Close()
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+			TLS:       &syslog.TLS{InsecureSkipVerify: true},
+			Required:  true,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBackpressure(true),
+			syslog.WithBufferSize(1),
+			syslog.WithDialTimeout(2*time.Second),
+		)
+
+		Eventually(func() bool {
+			return out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}).Should(BeFalse())
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("drops messages when the queue is full without backpressure", func() {
+		lis := blocked()
+		defer lis.Close()
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+			TLS:       &syslog.TLS{InsecureSkipVerify: true},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBufferSize(1),
+			syslog.WithDialTimeout(2*time.Second),
+		)
+
+		Eventually(func() bool {
+			return out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}).Should(BeFalse())
+		Expect(s.MessagesDropped()).To(Equal(int64(1)))
+	})
+
+	It("does not queue messages for any sink while one of them is down", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+		}
+		cs := &syslog.Sink{
+			Addr:     unreachable(),
+			Required: true,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			[]*syslog.Sink{cs},
+			syslog.WithBackpressure(true),
+			syslog.WithReconnectBackoff(time.Hour, time.Hour),
+		)
+
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+		Eventually(cs.MessagesDropped).Should(Equal(int64(1)))
+		Eventually(c.received).Should(HaveLen(1))
+
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeFalse())
+		Consistently(c.received, 100*time.Millisecond).Should(HaveLen(1))
+		Expect(cs.MessagesDropped()).To(Equal(int64(1)))
+	})
+
+	It("queues messages for the other sinks while a sink that is not required is down", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			Required:  true,
+		}
+		cs := &syslog.Sink{
+			Addr: unreachable(),
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			[]*syslog.Sink{cs},
+			syslog.WithBackpressure(true),
+			syslog.WithReconnectBackoff(time.Hour, time.Hour),
+		)
+
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+		Eventually(cs.MessagesDropped).Should(Equal(int64(1)))
+
+		Expect(out.WriteChunk([]syslog.Record{{Record: record, Time: time.Unix(0, 0).UTC()}}, "pod.log")).To(BeTrue())
+		Eventually(c.received).Should(HaveLen(2))
+		Eventually(cs.MessagesDropped).Should(Equal(int64(2)))
+	})
+
+	Describe("WriteChunk", func() {
+		chunk := func(n int) []syslog.Record {
+			records := make([]syslog.Record, n)
+			for i := range records {
+				records[i] = syslog.Record{Record: record, Time: time.Unix(0, 0).UTC()}
+			}
+			return records
+		}
+
+		It("queues all records of the chunk", func() {
+			c := newCollector()
+			defer c.stop()
+			s := &syslog.Sink{
+				Addr:      c.url(),
+				Namespace: "some-ns",
+				Required:  true,
+			}
+			out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithBackpressure(true))
+
+			Expect(out.WriteChunk(chunk(3), "pod.log")).To(BeTrue())
+			Eventually(c.received).Should(HaveLen(3))
+		})
+
+		It("queues a chunk larger than the queue once the queue is empty", func() {
+			c := newCollector()
+			defer c.stop()
+			s := &syslog.Sink{
+				Addr:      c.url(),
+				Namespace: "some-ns",
+				Required:  true,
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithBackpressure(true),
+				syslog.WithBufferSize(4),
+			)
+
+			Expect(out.WriteChunk(chunk(10), "pod.log")).To(BeTrue())
+			Eventually(c.received).Should(HaveLen(10))
+			Expect(s.MessagesDropped()).To(BeZero())
+		})
+
+		It("queues none of the records unless the queue can hold all of them", func() {
+			lis := blocked()
+			defer lis.Close()
+			s := &syslog.Sink{
+				Addr:      lis.Addr().String(),
+				Namespace: "some-ns",
+				TLS:       &syslog.TLS{InsecureSkipVerify: true},
+				Required:  true,
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithBackpressure(true),
+				syslog.WithBufferSize(4),
+				syslog.WithDialTimeout(2*time.Second),
+			)
+
+			Expect(out.WriteChunk(chunk(3), "pod.log")).To(BeTrue())
+			Expect(out.WriteChunk(chunk(3), "pod.log")).To(BeFalse())
+			Expect(s.MessagesDropped()).To(BeZero())
+		})
+
+		It("queues none of the records for any sink while one of them is down", func() {
+			c := newCollector()
+			defer c.stop()
+			s := &syslog.Sink{
+				Addr:      c.url(),
+				Namespace: "some-ns",
+				Required:  true,
+			}
+			cs := &syslog.Sink{
+				Addr:     unreachable(),
+				Required: true,
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				[]*syslog.Sink{cs},
+				syslog.WithBackpressure(true),
+				syslog.WithReconnectBackoff(time.Hour, time.Hour),
+			)
+
+			Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+			Eventually(cs.MessagesDropped).Should(Equal(int64(1)))
+			Eventually(c.received).Should(HaveLen(1))
+
+			Expect(out.WriteChunk(chunk(3), "pod.log")).To(BeFalse())
+			Consistently(c.received, 100*time.Millisecond).Should(HaveLen(1))
+		})
+	})
+
+	It("accepts messages again once the sink may reconnect", func() {
+		addr := unreachable()
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+			Required:  true,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBackpressure(true),
+			syslog.WithReconnectBackoff(100*time.Millisecond, 100*time.Millisecond),
+		)
+
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.Write(record, time.Unix(0, 0).UTC(), "pod.log")).To(BeFalse())
+
+		c := newCollector(addr)
+		defer c.stop()
+		Eventually(func() bool {
+			return out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}).Should(BeTrue())
+		Eventually(c.received).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,377 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+)
+
+// Load balancing modes of a sink.
+const (
+	// LoadBalancingRoundRobin sends each message to the next address of
+	// the sink.
+	LoadBalancingRoundRobin = "round-robin"
+	// LoadBalancingHash sends all messages of a pod to the same address so
+	// that their order is preserved.
+	LoadBalancingHash = "hash"
+)
+
+// ringReplicas is the number of points each address has on the hash ring.
+const ringReplicas = 100
+
+func validateLoadBalancing(lb, transport string) error {
+	switch lb {
+	case "":
+		return nil
+	case LoadBalancingRoundRobin, LoadBalancingHash:
+	default:
+		return fmt.Errorf("unsupported load balancing %q", lb)
+	}
+
+	switch transport {
+	case "", TransportTCP, TransportUDP:
+		return nil
+	}
+	return fmt.Errorf("transport %s does not support load balancing", transport)
+}
+
+// balancer spreads the messages of a sink across all of its addresses,
+// each with its own connection. Addresses that fail are ejected for a while
+// and their messages are sent to the remaining addresses.
+type balancer struct {
+	sink     *Sink
+	out      *Out
+	network  string
+	ejectFor time.Duration
+	checked  time.Time
+
+	mu      sync.Mutex
+	members []*member
+	ring    []ringPoint
+	next    int
+}
+
+type member struct {
+	addr         string
+	conn         net.Conn
+	ejectedUntil time.Time
+	err          SinkError
+}
+
+type ringPoint struct {
+	hash   uint32
+	member *member
+}
+
+func newBalancer(s *Sink, out *Out) *balancer {
+	network := "tcp"
+	if s.Transport == TransportUDP {
+		network = TransportUDP
+	}
+	return &balancer{
+		sink:     s,
+		out:      out,
+		network:  network,
+		ejectFor: out.ejectionDuration,
+	}
+}
+
+// maintainMembers updates the members when the addresses of the sink
+// changed. Connections of a TLS sink are closed when its CA or client
+// certificate files changed.
+func (b *balancer) maintainMembers() error {
+	err := b.sink.ensureResolved()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sink.TLS != nil &&
+		b.sink.tlsReloadInterval > 0 &&
+		time.Since(b.checked) >= b.sink.tlsReloadInterval {
+		b.checked = time.Now()
+		if b.sink.tlsFingerprint != "" && tlsFingerprint(b.sink.TLS) != b.sink.tlsFingerprint {
+			log.Printf("Sink to address %s, at namespace [%s] reconnecting after TLS files changed\n", b.sink.Addr, b.sink.Namespace)
+			for _, m := range b.members {
+				m.disconnect()
+			}
+		}
+	}
+
+	addrs := b.sink.addrs()
+	if b.hasMembers(addrs) {
+		return nil
+	}
+
+	existing := make(map[string]*member)
+	for _, m := range b.members {
+		existing[m.addr] = m
+	}
+	members := make([]*member, 0, len(addrs))
+	for _, addr := range addrs {
+		m, ok := existing[addr]
+		if !ok {
+			m = &member{addr: addr}
+		}
+		delete(existing, addr)
+		members = append(members, m)
+	}
+	for _, m := range existing {
+		m.disconnect()
+	}
+
+	b.members = members
+	b.ring = buildRing(members)
+	b.next = 0
+	return nil
+}
+
+func (b *balancer) hasMembers(addrs []string) bool {
+	if len(addrs) != len(b.members) {
+		return false
+	}
+	for i, addr := range addrs {
+		if b.members[i].addr != addr {
+			return false
+		}
+	}
+	return true
+}
+
+// send writes the message to the member picked by the sink's load
+// balancing mode. If that member fails it is ejected and the message is
+// sent to the next member.
+func (b *balancer) send(w io.WriterTo) error {
+	msg, err := b.encode(w)
+	if err != nil {
+		return err
+	}
+
+	var key string
+	if b.sink.LoadBalancing == LoadBalancingHash {
+		key = shardKey(w)
+	}
+
+	b.mu.Lock()
+	candidates := b.pick(key, time.Now())
+	b.mu.Unlock()
+	if len(candidates) == 0 {
+		return errors.New("all load balanced addresses are ejected")
+	}
+
+	for _, m := range candidates {
+		err = b.write(m, msg)
+		if err == nil {
+			return nil
+		}
+		b.eject(m, err)
+	}
+	return err
+}
+
+func (b *balancer) encode(w io.WriterTo) ([]byte, error) {
+	if b.network == TransportUDP {
+		return b.sink.datagram(w)
+	}
+	m, err := b.sink.marshal(w)
+	if err != nil {
+		return nil, err
+	}
+	return b.sink.frame(m), nil
+}
+
+// pick returns the members that are not ejected in the order they should
+// be tried. It must be called with mu held.
+func (b *balancer) pick(key string, now time.Time) []*member {
+	var ordered []*member
+	if b.sink.LoadBalancing == LoadBalancingHash {
+		ordered = b.lookupRing(key)
+	} else {
+		ordered = make([]*member, 0, len(b.members))
+		for i := range b.members {
+			ordered = append(ordered, b.members[(b.next+i)%len(b.members)])
+		}
+		if len(b.members) != 0 {
+			b.next = (b.next + 1) % len(b.members)
+		}
+	}
+
+	healthy := ordered[:0]
+	for _, m := range ordered {
+		if !now.Before(m.ejectedUntil) {
+			healthy = append(healthy, m)
+		}
+	}
+	return healthy
+}
+
+// lookupRing returns the members in the order they follow the key on the
+// hash ring.
+func (b *balancer) lookupRing(key string) []*member {
+	if len(b.ring) == 0 {
+		return nil
+	}
+
+	h := hash(key)
+	start := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+
+	seen := make(map[*member]bool)
+	ordered := make([]*member, 0, len(b.members))
+	for i := 0; i < len(b.ring) && len(ordered) < len(b.members); i++ {
+		m := b.ring[(start+i)%len(b.ring)].member
+		if !seen[m] {
+			seen[m] = true
+			ordered = append(ordered, m)
+		}
+	}
+	return ordered
+}
+
+func (b *balancer) write(m *member, msg []byte) error {
+	if m.conn == nil {
+		conn, err := b.dial(m.addr)
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		m.conn = conn
+		b.mu.Unlock()
+	}
+
+	_ = m.conn.SetWriteDeadline(time.Now().Add(b.sink.writeTimeout))
+	_, err := m.conn.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	m.err = SinkError{}
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *balancer) dial(addr string) (net.Conn, error) {
+	if b.sink.TLS != nil {
+		return dialTLS(b.sink, addr)
+	}
+	return b.sink.dial(b.network, addr, b.sink.dialTimeout)
+}
+
+func (b *balancer) eject(m *member, err error) {
+	log.Printf("Sink to address %s, at namespace [%s] ejected %s: %s\n", b.sink.Addr, b.sink.Namespace, m.addr, err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m.disconnect()
+	m.ejectedUntil = time.Now().Add(b.ejectFor)
+	m.err = SinkError{
+		Msg:       err.Error(),
+		Timestamp: time.Now(),
+	}
+}
+
+// state returns the state of the connection to each member.
+func (b *balancer) state() []ConnectionState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make([]ConnectionState, 0, len(b.members))
+	for _, m := range b.members {
+		state := ConnectionState{
+			Addr:      m.addr,
+			Connected: m.conn != nil,
+		}
+		if time.Now().Before(m.ejectedUntil) {
+			t := m.ejectedUntil
+			state.EjectedUntil = &t
+		}
+		if m.err.Msg != "" {
+			err := m.err
+			state.Error = &err
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// close closes the connections to all members.
+func (b *balancer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		m.disconnect()
+	}
+}
+
+func (m *member) disconnect() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+func buildRing(members []*member) []ringPoint {
+	ring := make([]ringPoint, 0, len(members)*ringReplicas)
+	for _, m := range members {
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, ringPoint{
+				hash:   hash(m.addr + "#" + strconv.Itoa(i)),
+				member: m,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return ring
+}
+
+// hash returns the FNV-1a hash of s. Since keys such as pod names often
+// only differ in their last characters the hash is finalized like
+// MurmurHash3 to spread them across the ring.
+func hash(s string) uint32 {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(s))
+	h := f.Sum32()
+
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// shardKey returns the namespace and pod name of the message.
+func shardKey(w io.WriterTo) string {
+	m, ok := w.(*rfc5424.Message)
+	if !ok {
+		return ""
+	}
+
+	var namespace, pod string
+	for _, sd := range m.StructuredData {
+		for _, p := range sd.Parameters {
+			switch p.Name {
+			case "namespace_name":
+				namespace = p.Value
+			case "object_name":
+				pod = p.Value
+			}
+		}
+	}
+	return namespace + "/" + pod
+}
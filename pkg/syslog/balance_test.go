@@ -0,0 +1,280 @@
+package syslog_test
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// collector accepts any number of connections and records the messages of
+// all of them.
+type collector struct {
+	lis net.Listener
+
+	mu    sync.Mutex
+	msgs  []string
+	conns [][]string
+}
+
+func newCollector(addr ...string) *collector {
+	a := "127.0.0.1:0"
+	if len(addr) != 0 {
+		a = addr[0]
+	}
+	lis, err := net.Listen("tcp", a)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	c := &collector{
+		lis: lis,
+	}
+	go c.serve()
+	return c
+}
+
+func (c *collector) url() string {
+	return c.lis.Addr().String()
+}
+
+func (c *collector) stop() {
+	_ = c.lis.Close()
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.msgs...)
+}
+
+// receivedByConn returns the messages received on each connection.
+func (c *collector) receivedByConn() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := make([][]string, 0, len(c.conns))
+	for _, msgs := range c.conns {
+		conns = append(conns, append([]string(nil), msgs...))
+	}
+	return conns
+}
+
+func (c *collector) serve() {
+	for {
+		conn, err := c.lis.Accept()
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		i := len(c.conns)
+		c.conns = append(c.conns, nil)
+		c.mu.Unlock()
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				var msg rfc5424.Message
+				_, err := msg.ReadFrom(r)
+				if err != nil {
+					return
+				}
+				c.mu.Lock()
+				c.msgs = append(c.msgs, string(msg.Message))
+				c.conns[i] = append(c.conns[i], string(msg.Message))
+				c.mu.Unlock()
+			}
+		}()
+	}
+}
+
+var _ = Describe("Load balancing", func() {
+	var record func(pod, msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(pod, msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+					"pod_name":       []byte(pod),
+				},
+			}
+		}
+	})
+
+	It("sends messages to each address in turn", func() {
+		first := newCollector()
+		defer first.stop()
+		second := newCollector()
+		defer second.stop()
+
+		s := &syslog.Sink{
+			Addr:          first.url(),
+			FailoverAddrs: []string{second.url()},
+			Namespace:     "some-ns",
+			LoadBalancing: syslog.LoadBalancingRoundRobin,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		for _, msg := range []string{"log-1", "log-2", "log-3", "log-4"} {
+			out.Write(record("pod", msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(first.received).Should(Equal([]string{"log-1\n", "log-3\n"}))
+		Eventually(second.received).Should(Equal([]string{"log-2\n", "log-4\n"}))
+
+		state := out.SinkState()[0]
+		Expect(state.ActiveAddr).To(BeEmpty())
+		Expect(state.Connections).To(HaveLen(2))
+		Expect(state.Connections[0].Addr).To(Equal(first.url()))
+		Expect(state.Connections[0].Connected).To(BeTrue())
+		Expect(state.Connections[1].Addr).To(Equal(second.url()))
+		Expect(state.Connections[1].Connected).To(BeTrue())
+	})
+
+	It("sends all messages of a pod to the same address", func() {
+		collectors := []*collector{newCollector(), newCollector(), newCollector()}
+		for _, c := range collectors {
+			defer c.stop()
+		}
+
+		s := &syslog.Sink{
+			Addr:          collectors[0].url(),
+			FailoverAddrs: []string{collectors[1].url(), collectors[2].url()},
+			Namespace:     "some-ns",
+			LoadBalancing: syslog.LoadBalancingHash,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		pods := []string{"pod-a", "pod-b", "pod-c", "pod-d", "pod-e", "pod-f"}
+		for i := 0; i < 5; i++ {
+			for _, pod := range pods {
+				out.Write(record(pod, pod), time.Unix(0, 0).UTC(), "pod.log")
+			}
+		}
+
+		Eventually(func() int {
+			var n int
+			for _, c := range collectors {
+				n += len(c.received())
+			}
+			return n
+		}).Should(Equal(5 * len(pods)))
+
+		var used int
+		for _, pod := range pods {
+			var receivers int
+			for _, c := range collectors {
+				var n int
+				for _, msg := range c.received() {
+					if msg == pod+"\n" {
+						n++
+					}
+				}
+				if n != 0 {
+					Expect(n).To(Equal(5))
+					receivers++
+				}
+			}
+			Expect(receivers).To(Equal(1))
+		}
+		for _, c := range collectors {
+			if len(c.received()) != 0 {
+				used++
+			}
+		}
+		Expect(used).To(BeNumerically(">", 1))
+	})
+
+	It("ejects addresses that fail and re-admits them later", func() {
+		healthy := newCollector()
+		defer healthy.stop()
+		failing := newCollector()
+		failingAddr := failing.url()
+		failing.stop()
+
+		s := &syslog.Sink{
+			Addr:          healthy.url(),
+			FailoverAddrs: []string{failingAddr},
+			Namespace:     "some-ns",
+			LoadBalancing: syslog.LoadBalancingRoundRobin,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithEjectionDuration(200*time.Millisecond),
+		)
+
+		for _, msg := range []string{"log-1", "log-2", "log-3", "log-4"} {
+			out.Write(record("pod", msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(healthy.received).Should(Equal([]string{"log-1\n", "log-2\n", "log-3\n", "log-4\n"}))
+		Expect(s.MessagesDropped()).To(Equal(int64(0)))
+		state := out.SinkState()[0].Connections[1]
+		Expect(state.Addr).To(Equal(failingAddr))
+		Expect(state.Connected).To(BeFalse())
+		Expect(state.EjectedUntil).ToNot(BeNil())
+		Expect(state.Error).ToNot(BeNil())
+
+		failing = newCollector(failingAddr)
+		defer failing.stop()
+		Eventually(func() *time.Time {
+			return out.SinkState()[0].Connections[1].EjectedUntil
+		}).Should(BeNil())
+
+		for _, msg := range []string{"log-5", "log-6"} {
+			out.Write(record("pod", msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(failing.received).Should(HaveLen(1))
+		Eventually(func() int {
+			return len(healthy.received())
+		}).Should(Equal(5))
+	})
+
+	It("drops messages when every address is ejected", func() {
+		failing := newCollector()
+		failing.stop()
+
+		s := &syslog.Sink{
+			Addr:          failing.url(),
+			Namespace:     "some-ns",
+			LoadBalancing: syslog.LoadBalancingRoundRobin,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("pod", "log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("pod", "log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(2)))
+		Expect(out.SinkState()[0].Error.Msg).To(Equal("all load balanced addresses are ejected"))
+	})
+
+	DescribeTable("validates the load balancing", func(lb, transport string, valid bool) {
+		s := &syslog.Sink{
+			Addr:          "localhost:514",
+			LoadBalancing: lb,
+			Transport:     transport,
+		}
+
+		err := s.Validate()
+
+		if valid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("round-robin", syslog.LoadBalancingRoundRobin, syslog.TransportTCP, true),
+		Entry("hash", syslog.LoadBalancingHash, syslog.TransportUDP, true),
+		Entry("unsupported mode", "random", syslog.TransportTCP, false),
+		Entry("relp", syslog.LoadBalancingRoundRobin, syslog.TransportRELP, false),
+		Entry("unix", syslog.LoadBalancingHash, syslog.TransportUnix, false),
+	)
+})
@@ -0,0 +1,50 @@
+package syslog
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// batch adds the framed messages queued behind the current one to buf
+// until it holds size bytes. If the queue is empty batch waits up to linger
+// for more messages. It returns the messages added to buf.
+func (s *Sink) batch(buf *bytes.Buffer, size int, linger time.Duration) []io.WriterTo {
+	var batched []io.WriterTo
+	var timer *time.Timer
+	for buf.Len() < size {
+		var (
+			m  io.WriterTo
+			ok bool
+		)
+		select {
+		case m, ok = <-s.messages:
+		default:
+			if linger <= 0 {
+				return batched
+			}
+			if timer == nil {
+				timer = time.NewTimer(linger)
+				defer timer.Stop()
+			}
+			select {
+			case m, ok = <-s.messages:
+			case <-timer.C:
+				return batched
+			}
+		}
+		if !ok {
+			return batched
+		}
+
+		b, err := s.marshal(m)
+		if err != nil {
+			atomic.AddInt64(&s.messagesDropped, 1)
+			continue
+		}
+		buf.Write(s.frame(b))
+		batched = append(batched, m)
+	}
+	return batched
+}
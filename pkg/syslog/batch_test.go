@@ -0,0 +1,270 @@
+package syslog_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Write batching", func() {
+	var record func(msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+				},
+			}
+		}
+	})
+
+	It("writes the queued messages together", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithWriteBatch(64*1024, 10*time.Millisecond),
+		)
+
+		var expected []string
+		for i := 0; i < 1000; i++ {
+			msg := "log-" + strconv.Itoa(i)
+			expected = append(expected, msg+"\n")
+			out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(c.received).Should(Equal(expected))
+		Expect(s.MessagesSent()).To(Equal(int64(1000)))
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("waits for more messages up to the linger time", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithWriteBatch(64*1024, 500*time.Millisecond),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Consistently(c.received, 200*time.Millisecond).Should(BeEmpty())
+		Eventually(c.received).Should(Equal([]string{"log-1\n", "log-2\n"}))
+	})
+
+	Describe("when the connection resets", func() {
+		var (
+			lis net.Listener
+			dir string
+		)
+
+		BeforeEach(func() {
+			var err error
+			lis, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			_ = lis.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+			dir, err = ioutil.TempDir("", "batch")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			_ = lis.Close()
+			_ = os.RemoveAll(dir)
+		})
+
+		// sendAfterReset writes a message, resets the connection once it
+		// was received and writes ten messages that are batched
+		// together. It returns the messages received afterwards.
+		sendAfterReset := func(out *syslog.Out) []string {
+			out.Write(record("log-0"), time.Unix(0, 0).UTC(), "pod.log")
+			conn, err := lis.Accept()
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			r := bufio.NewReader(conn)
+			var msg rfc5424.Message
+			_, err = msg.ReadFrom(r)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			_ = conn.(*net.TCPConn).SetLinger(0)
+			_ = conn.Close()
+			time.Sleep(100 * time.Millisecond)
+
+			for i := 1; i <= 10; i++ {
+				out.Write(record("log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+			}
+
+			conn, err = lis.Accept()
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			r = bufio.NewReader(conn)
+			var received []string
+			for len(received) < 10 {
+				var msg rfc5424.Message
+				_, err := msg.ReadFrom(r)
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				received = append(received, string(msg.Message))
+			}
+			return received
+		}
+
+		var expected []string
+		for i := 1; i <= 10; i++ {
+			expected = append(expected, "log-"+strconv.Itoa(i)+"\n")
+		}
+
+		It("writes the whole batch again when the message is retried", func() {
+			s := &syslog.Sink{
+				Addr:      lis.Addr().String(),
+				Namespace: "some-ns",
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithWriteBatch(64*1024, 50*time.Millisecond),
+			)
+
+			Expect(sendAfterReset(out)).To(Equal(expected))
+			Expect(s.MessagesRetried()).To(Equal(int64(1)))
+			Eventually(s.MessagesSent).Should(Equal(int64(11)))
+			Expect(s.MessagesDropped()).To(BeZero())
+		})
+
+		It("holds the whole batch of a sink with a disk queue", func() {
+			s := &syslog.Sink{
+				Addr:      lis.Addr().String(),
+				Namespace: "some-ns",
+				DiskQueue: &syslog.DiskQueue{Dir: dir},
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithRetry(0, 0),
+				syslog.WithReconnectBackoff(10*time.Millisecond, 10*time.Millisecond),
+				syslog.WithWriteBatch(64*1024, 50*time.Millisecond),
+			)
+
+			Expect(sendAfterReset(out)).To(Equal(expected))
+			Expect(s.MessagesRetried()).To(BeZero())
+			Eventually(s.MessagesSent).Should(Equal(int64(11)))
+			Expect(s.MessagesDropped()).To(BeZero())
+		})
+	})
+
+	It("writes the batch once it reaches the batch size", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithWriteBatch(1, time.Hour),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(c.received).Should(Equal([]string{"log-1\n"}))
+	})
+})
+
+func BenchmarkStreamSink(b *testing.B) {
+	cert, err := tls.LoadX509KeyPair("./testdata/server.crt", "./testdata/server.key")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name string
+		tls  bool
+		opts []syslog.OutOption
+	}{
+		{name: "tcp"},
+		{name: "tcp-batched", opts: []syslog.OutOption{syslog.WithWriteBatch(64*1024, 0)}},
+		{name: "tls", tls: true},
+		{name: "tls-batched", tls: true, opts: []syslog.OutOption{syslog.WithWriteBatch(64*1024, 0)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer lis.Close()
+			if bc.tls {
+				lis = tls.NewListener(lis, &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				})
+			}
+			go discard(lis)
+
+			s := &syslog.Sink{
+				Addr: lis.Addr().String(),
+			}
+			if bc.tls {
+				s.TLS = &syslog.TLS{InsecureSkipVerify: true}
+			}
+			opts := append([]syslog.OutOption{syslog.WithBufferSize(b.N)}, bc.opts...)
+			out := syslog.NewOut(nil, []*syslog.Sink{s}, opts...)
+			record := map[interface{}]interface{}{
+				"log": []byte("a high volume log message of a typical size written by some application"),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-namespace"),
+					"pod_name":       []byte("some-pod"),
+					"container_name": []byte("some-container"),
+				},
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+			}
+			for s.MessagesSent()+s.MessagesDropped() < int64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+			if s.MessagesDropped() != 0 {
+				b.Fatalf("dropped %d messages", s.MessagesDropped())
+			}
+		})
+	}
+}
+
+// discard accepts connections and discards everything they receive.
+func discard(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(ioutil.Discard, conn)
+		}()
+	}
+}
@@ -0,0 +1,206 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+)
+
+// CheckTLS loads the CA and client certificates of the sink and checks
+// that every certificate is currently valid and that the client
+// certificate chain is in order, each certificate signed by the next one.
+func (s *Sink) CheckTLS() error {
+	if s.TLS == nil {
+		return nil
+	}
+	err := validateTLS(s.TLS)
+	if err != nil {
+		return err
+	}
+	_, err = loadTLSMaterial(s.TLS)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	if !s.TLS.InsecureSkipVerify && s.TLS.RootCA != "" {
+		bundle, err := ioutil.ReadFile(s.TLS.RootCA)
+		if err != nil {
+			return err
+		}
+		certs, err := parseCertificates(bundle)
+		if err != nil {
+			return fmt.Errorf("root ca %s: %s", s.TLS.RootCA, err)
+		}
+		for _, c := range certs {
+			err = checkValidity(c, now)
+			if err != nil {
+				return fmt.Errorf("root ca %s: %s", s.TLS.RootCA, err)
+			}
+		}
+	}
+
+	if s.TLS.Cert != "" {
+		pair, err := tls.LoadX509KeyPair(s.TLS.Cert, s.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		chain := make([]*x509.Certificate, 0, len(pair.Certificate))
+		for _, der := range pair.Certificate {
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("cert %s: %s", s.TLS.Cert, err)
+			}
+			chain = append(chain, c)
+		}
+		for i, c := range chain {
+			err = checkValidity(c, now)
+			if err != nil {
+				return fmt.Errorf("cert %s: %s", s.TLS.Cert, err)
+			}
+			if i == 0 {
+				continue
+			}
+			err = chain[i-1].CheckSignatureFrom(c)
+			if err != nil {
+				return fmt.Errorf("cert %s: certificate %q is not signed by %q: %s", s.TLS.Cert, chain[i-1].Subject.CommonName, c.Subject.CommonName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseCertificates returns the certificates of the PEM bundle.
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+func checkValidity(c *x509.Certificate, now time.Time) error {
+	if now.Before(c.NotBefore) {
+		return fmt.Errorf("certificate %q is not valid before %s", c.Subject.CommonName, c.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(c.NotAfter) {
+		return fmt.Errorf("certificate %q expired at %s", c.Subject.CommonName, c.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Dial connects the sink to its destination with the options of out and
+// closes the connection again. No messages are sent. Sinks with failover
+// addresses succeed if any address is reachable while load balanced sinks
+// require every address to be reachable. HTTPS sinks connect to the host
+// of the drain URL.
+func (o *Out) Dial(s *Sink) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+	o.configure(s)
+
+	switch {
+	case s.LoadBalancing != "":
+		return dialMembers(s)
+	case s.Transport == TransportHTTPS:
+		return s.failover(func(addr string) error {
+			return dialDrain(s, addr)
+		})
+	}
+
+	setupTransport(s, o)
+	defer s.closeConnection()
+	return s.maintainConnection()
+}
+
+// dialMembers connects to every address of a load balanced sink.
+func dialMembers(s *Sink) error {
+	err := s.ensureResolved()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range s.addrs() {
+		var conn net.Conn
+		switch {
+		case s.TLS != nil:
+			conn, err = dialTLS(s, addr)
+		case s.Transport == TransportUDP:
+			conn, err = s.dial(TransportUDP, addr, s.dialTimeout)
+		default:
+			conn, err = s.dial("tcp", addr, s.dialTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", addr, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// dialDrain connects to the host of the HTTPS drain URL and completes the
+// TLS handshake for https URLs.
+func dialDrain(s *Sink, addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	if u.Scheme == "http" {
+		conn, err := s.dial("tcp", host, s.dialTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	config := &tls.Config{}
+	if s.TLS != nil {
+		config, err = s.tlsConfig()
+		if err != nil {
+			return err
+		}
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	conn, err := s.dial("tcp", host, s.dialTimeout)
+	if err != nil {
+		return err
+	}
+	tlsConn := tls.Client(conn, config)
+	_ = tlsConn.SetDeadline(time.Now().Add(s.dialTimeout))
+	err = tlsConn.Handshake()
+	conn.Close()
+	return err
+}
@@ -0,0 +1,159 @@
+package syslog_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Check", func() {
+	Describe("CheckTLS", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "out-syslog-check")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			_ = os.RemoveAll(dir)
+		})
+
+		concat := func(name string, srcs ...string) string {
+			var b []byte
+			for _, src := range srcs {
+				data, err := ioutil.ReadFile(src)
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				b = append(b, data...)
+			}
+			path := filepath.Join(dir, name)
+			ExpectWithOffset(1, ioutil.WriteFile(path, b, 0600)).To(Succeed())
+			return path
+		}
+
+		It("accepts valid CA and client certificates", func() {
+			s := &syslog.Sink{
+				TLS: &syslog.TLS{
+					RootCA: "./testdata/rootCA.crt",
+					Cert:   "./testdata/client.crt",
+					Key:    "./testdata/client.key",
+				},
+			}
+			Expect(s.CheckTLS()).To(Succeed())
+			Expect((&syslog.Sink{}).CheckTLS()).To(Succeed())
+		})
+
+		It("reports expired CA certificates", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			template := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "expired-ca"},
+				NotBefore:             time.Now().Add(-48 * time.Hour),
+				NotAfter:              time.Now().Add(-24 * time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			Expect(err).ToNot(HaveOccurred())
+			expired := filepath.Join(dir, "expired.crt")
+			Expect(ioutil.WriteFile(expired, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+
+			s := &syslog.Sink{
+				TLS: &syslog.TLS{RootCA: concat("ca.crt", "./testdata/rootCA.crt", expired)},
+			}
+			Expect(s.CheckTLS()).To(MatchError(ContainSubstring(`certificate "expired-ca" expired at`)))
+		})
+
+		It("reports client certificate chains that are out of order", func() {
+			s := &syslog.Sink{
+				TLS: &syslog.TLS{
+					Cert: concat("client.crt", "./testdata/client.crt", "./testdata/server.crt"),
+					Key:  "./testdata/client.key",
+				},
+			}
+			Expect(s.CheckTLS()).To(MatchError(ContainSubstring(`certificate "client" is not signed by "oratos"`)))
+		})
+
+		It("reports missing files", func() {
+			s := &syslog.Sink{
+				TLS: &syslog.TLS{RootCA: filepath.Join(dir, "missing.crt")},
+			}
+			Expect(s.CheckTLS()).To(MatchError(ContainSubstring("no such file")))
+		})
+	})
+
+	Describe("Dial", func() {
+		It("connects to the sink", func() {
+			spySink := newSpySink("127.0.0.1:0")
+			defer spySink.stop()
+
+			out := syslog.NewOut(nil, nil)
+			Expect(out.Dial(&syslog.Sink{Name: "a", Addr: spySink.url()})).To(Succeed())
+		})
+
+		It("completes the TLS handshake", func() {
+			spySink := newTLSSpySink("127.0.0.1:0")
+			defer spySink.stop()
+			go func() {
+				defer GinkgoRecover()
+				conn := spySink.accept()
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+
+			out := syslog.NewOut(nil, nil)
+			err := out.Dial(&syslog.Sink{
+				Name: "a",
+				Addr: spySink.url(),
+				TLS:  &syslog.TLS{InsecureSkipVerify: true},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("connects to the host of HTTPS drains", func() {
+			server := httptest.NewTLSServer(http.NotFoundHandler())
+			defer server.Close()
+
+			out := syslog.NewOut(nil, nil)
+			err := out.Dial(&syslog.Sink{
+				Name:      "a",
+				Addr:      server.URL,
+				Transport: syslog.TransportHTTPS,
+				TLS:       &syslog.TLS{InsecureSkipVerify: true},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("reports unreachable sinks", func() {
+			spySink := newSpySink("127.0.0.1:0")
+			spySink.stop()
+
+			out := syslog.NewOut(nil, nil, syslog.WithDialTimeout(time.Second))
+			Expect(out.Dial(&syslog.Sink{Name: "a", Addr: spySink.url()})).ToNot(Succeed())
+		})
+
+		It("reports invalid sinks", func() {
+			out := syslog.NewOut(nil, nil)
+			err := out.Dial(&syslog.Sink{Name: "a", Addr: "localhost:514", Transport: "carrier-pigeon"})
+			Expect(err).To(MatchError(ContainSubstring("unsupported transport")))
+		})
+	})
+})
@@ -0,0 +1,190 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SinkConfig declares a sink in a sinks file.
+type SinkConfig struct {
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Cluster makes the sink receive the messages of all namespaces.
+	Cluster       bool       `json:"cluster" yaml:"cluster"`
+	Addr          string     `json:"addr" yaml:"addr"`
+	Transport     string     `json:"transport" yaml:"transport"`
+	Framing       string     `json:"framing" yaml:"framing"`
+	Format        string     `json:"format" yaml:"format"`
+	TLS           *TLS       `json:"tls" yaml:"tls"`
+	Proxy         string     `json:"proxy" yaml:"proxy"`
+	FailoverAddrs []string   `json:"failover_addrs" yaml:"failover_addrs"`
+	Discovery     string     `json:"discovery" yaml:"discovery"`
+	LoadBalancing string     `json:"load_balancing" yaml:"load_balancing"`
+	Workers       int        `json:"workers" yaml:"workers"`
+	ShardByPod    bool       `json:"shard_by_pod" yaml:"shard_by_pod"`
+	Required      bool       `json:"required" yaml:"required"`
+	DiskQueue     *DiskQueue `json:"disk_queue" yaml:"disk_queue"`
+	// DialTimeout, WriteTimeout and BufferSize override the settings of
+	// the plugin for the sink.
+	DialTimeout  Duration `json:"dial_timeout" yaml:"dial_timeout"`
+	WriteTimeout Duration `json:"write_timeout" yaml:"write_timeout"`
+	BufferSize   int      `json:"buffer_size" yaml:"buffer_size"`
+}
+
+// Duration is a duration in a sinks file. It is written as a string with a
+// unit, e.g. "5s". Bare numbers are rejected since they would otherwise be
+// read as nanoseconds.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	err := unmarshal(&v)
+	if err != nil {
+		return err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("duration %v must be a string with a unit, e.g. \"5s\"", v)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// SinksFile is the document of a sinks file.
+type SinksFile struct {
+	Sinks []SinkConfig `json:"sinks" yaml:"sinks"`
+}
+
+// Sink returns the sink the config declares.
+func (c SinkConfig) Sink() *Sink {
+	return &Sink{
+		Addr:          c.Addr,
+		Name:          c.Name,
+		Namespace:     c.Namespace,
+		TLS:           c.TLS,
+		Transport:     strings.ToLower(c.Transport),
+		Framing:       strings.ToLower(c.Framing),
+		Format:        strings.ToLower(c.Format),
+		Proxy:         c.Proxy,
+		FailoverAddrs: c.FailoverAddrs,
+		Discovery:     strings.ToLower(c.Discovery),
+		LoadBalancing: strings.ToLower(c.LoadBalancing),
+		Workers:       c.Workers,
+		ShardByPod:    c.ShardByPod,
+		Required:      c.Required,
+		DiskQueue:     c.DiskQueue,
+		DialTimeout:   time.Duration(c.DialTimeout),
+		WriteTimeout:  time.Duration(c.WriteTimeout),
+		BufferSize:    c.BufferSize,
+	}
+}
+
+// config returns the config that declares s.
+func (s *Sink) config(cluster bool) SinkConfig {
+	return SinkConfig{
+		Name:          s.Name,
+		Namespace:     s.Namespace,
+		Cluster:       cluster,
+		Addr:          s.Addr,
+		Transport:     s.Transport,
+		Framing:       s.Framing,
+		Format:        s.Format,
+		TLS:           s.TLS,
+		Proxy:         s.Proxy,
+		FailoverAddrs: s.FailoverAddrs,
+		Discovery:     s.Discovery,
+		LoadBalancing: s.LoadBalancing,
+		Workers:       s.Workers,
+		ShardByPod:    s.ShardByPod,
+		Required:      s.Required,
+		DiskQueue:     s.DiskQueue,
+		DialTimeout:   Duration(s.DialTimeout),
+		WriteTimeout:  Duration(s.WriteTimeout),
+		BufferSize:    s.BufferSize,
+	}
+}
+
+func (c SinkConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if c.Cluster && c.Namespace != "" {
+		return errors.New("cluster sinks can not have a namespace")
+	}
+	if !c.Cluster && c.Namespace == "" {
+		return errors.New("namespace is required unless the sink is a cluster sink")
+	}
+	if c.Workers < 0 {
+		return errors.New("workers must not be negative")
+	}
+	return nil
+}
+
+// LoadSinksFile reads the sinks declared in the YAML or JSON file at path.
+func LoadSinksFile(path string) (sinks, clusterSinks []*Sink, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ParseSinks(data)
+}
+
+// ParseSinks parses a YAML or JSON sinks file and validates the sinks it
+// declares. Every sink needs a unique name.
+func ParseSinks(data []byte) (sinks, clusterSinks []*Sink, err error) {
+	var f SinksFile
+	// JSON documents are valid YAML as well.
+	err = yaml.UnmarshalStrict(data, &f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(f.Sinks) == 0 {
+		return nil, nil, errors.New("no sinks declared")
+	}
+
+	names := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for i, c := range f.Sinks {
+		err = c.validate()
+		if err == nil && names[c.Name] {
+			err = errors.New("name is not unique")
+		}
+		if err == nil && c.DiskQueue != nil && dirs[filepath.Clean(c.DiskQueue.Dir)] {
+			err = errors.New("disk queue dir is not unique")
+		}
+		if err == nil {
+			err = c.Sink().Validate()
+		}
+		if err != nil {
+			if c.Name == "" {
+				return nil, nil, fmt.Errorf("sink %d: %s", i, err)
+			}
+			return nil, nil, fmt.Errorf("sink %q: %s", c.Name, err)
+		}
+		names[c.Name] = true
+		if c.DiskQueue != nil {
+			dirs[filepath.Clean(c.DiskQueue.Dir)] = true
+		}
+
+		if c.Cluster {
+			clusterSinks = append(clusterSinks, c.Sink())
+		} else {
+			sinks = append(sinks, c.Sink())
+		}
+	}
+	return sinks, clusterSinks, nil
+}
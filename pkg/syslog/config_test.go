@@ -0,0 +1,190 @@
+package syslog_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Sinks file", func() {
+	It("parses namespace and cluster sinks from YAML", func() {
+		sinks, clusterSinks, err := syslog.ParseSinks([]byte(`
+sinks:
+- name: tenant-a
+  namespace: tenant-a
+  addr: logs.example.com:6514
+  transport: TCP
+  tls:
+    insecure_skip_verify: true
+    server_name: logs.example.com
+  failover_addrs:
+  - backup.example.com:6514
+- name: tenant-b
+  namespace: tenant-b
+  addr: localhost:514
+  workers: 2
+  shard_by_pod: true
+  dial_timeout: 2s
+  write_timeout: 500ms
+  buffer_size: 100
+- name: cluster
+  cluster: true
+  addr: localhost:1514
+  disk_queue:
+    dir: /var/spool/out-syslog/cluster
+`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sinks).To(Equal([]*syslog.Sink{
+			{
+				Name:      "tenant-a",
+				Namespace: "tenant-a",
+				Addr:      "logs.example.com:6514",
+				Transport: "tcp",
+				TLS: &syslog.TLS{
+					InsecureSkipVerify: true,
+					ServerName:         "logs.example.com",
+				},
+				FailoverAddrs: []string{"backup.example.com:6514"},
+			},
+			{
+				Name:         "tenant-b",
+				Namespace:    "tenant-b",
+				Addr:         "localhost:514",
+				Workers:      2,
+				ShardByPod:   true,
+				DialTimeout:  2 * time.Second,
+				WriteTimeout: 500 * time.Millisecond,
+				BufferSize:   100,
+			},
+		}))
+		Expect(clusterSinks).To(Equal([]*syslog.Sink{
+			{
+				Name: "cluster",
+				Addr: "localhost:1514",
+				DiskQueue: &syslog.DiskQueue{
+					Dir: "/var/spool/out-syslog/cluster",
+				},
+			},
+		}))
+	})
+
+	It("loads JSON files", func() {
+		dir, err := ioutil.TempDir("", "sinks-file")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "sinks.json")
+		err = ioutil.WriteFile(path, []byte(`{
+			"sinks": [
+				{"name": "tenant-a", "namespace": "tenant-a", "addr": "localhost:514"},
+				{"name": "cluster", "cluster": true, "addr": "localhost:1514", "tls": {"insecure_skip_verify": true}}
+			]
+		}`), 0600)
+		Expect(err).ToNot(HaveOccurred())
+
+		sinks, clusterSinks, err := syslog.LoadSinksFile(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sinks).To(HaveLen(1))
+		Expect(sinks[0].Namespace).To(Equal("tenant-a"))
+		Expect(clusterSinks).To(HaveLen(1))
+		Expect(clusterSinks[0].TLS).To(Equal(&syslog.TLS{InsecureSkipVerify: true}))
+	})
+
+	It("returns an error if the file can not be read", func() {
+		_, _, err := syslog.LoadSinksFile("/does/not/exist.yml")
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("validates the sinks", func(doc, msg string) {
+		_, _, err := syslog.ParseSinks([]byte(doc))
+		Expect(err).To(MatchError(ContainSubstring(msg)))
+	},
+		Entry("no sinks", `sinks: []`, "no sinks declared"),
+		Entry("unknown key", `
+sinks:
+- name: a
+  namespace: a
+  adr: localhost:514
+`, "field adr not found"),
+		Entry("missing name", `
+sinks:
+- namespace: a
+  addr: localhost:514
+`, "sink 0: name is required"),
+		Entry("missing addr", `
+sinks:
+- name: a
+  namespace: a
+`, `sink "a": addr is required`),
+		Entry("missing namespace", `
+sinks:
+- name: a
+  addr: localhost:514
+`, `sink "a": namespace is required`),
+		Entry("cluster sink with namespace", `
+sinks:
+- name: a
+  cluster: true
+  namespace: a
+  addr: localhost:514
+`, `sink "a": cluster sinks can not have a namespace`),
+		Entry("duplicate name", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+- name: a
+  namespace: b
+  addr: localhost:514
+`, `sink "a": name is not unique`),
+		Entry("duplicate disk queue dir", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+  disk_queue:
+    dir: /var/spool/out-syslog
+- name: b
+  namespace: b
+  addr: localhost:514
+  disk_queue:
+    dir: /var/spool/out-syslog/
+`, `sink "b": disk queue dir is not unique`),
+		Entry("invalid sink", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+  transport: carrier-pigeon
+`, `sink "a": unsupported transport`),
+		Entry("negative buffer size", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+  buffer_size: -1
+`, `sink "a": dial timeout, write timeout and buffer size must not be negative`),
+		Entry("timeout without a unit", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+  dial_timeout: 5
+`, `duration 5 must be a string with a unit, e.g. "5s"`),
+		Entry("invalid timeout", `
+sinks:
+- name: a
+  namespace: a
+  addr: localhost:514
+  write_timeout: soon
+`, `invalid duration`),
+	)
+})
@@ -0,0 +1,39 @@
+package syslog
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+)
+
+// datagramSend writes each message as a single unframed datagram as defined
+// in RFC 5426. Messages larger than the sink's maximum datagram size are
+// truncated and counted.
+func datagramSend(s *Sink) func(io.WriterTo) error {
+	return func(w io.WriterTo) error {
+		b, err := s.datagram(w)
+		if err != nil {
+			return err
+		}
+		_, err = s.conn.Write(b)
+		return err
+	}
+}
+
+// datagram returns the message truncated to the sink's maximum datagram
+// size. Truncations are logged for every 1000 messages truncated.
+func (s *Sink) datagram(w io.WriterTo) ([]byte, error) {
+	b, err := s.marshal(w)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.maxDatagramSize > 0 && len(b) > s.maxDatagramSize {
+		b = b[:s.maxDatagramSize]
+		mt := atomic.AddInt64(&s.messagesTruncated, 1)
+		if mt%1000 == 0 {
+			log.Printf("Sink to address %s, at namespace [%s] truncated %d messages\n", s.Addr, s.Namespace, mt)
+		}
+	}
+	return b, nil
+}
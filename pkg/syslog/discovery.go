@@ -0,0 +1,232 @@
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// DiscoveryDNS resolves the host of the sink's address to its A and
+	// AAAA records and connects to the resolved IP addresses.
+	DiscoveryDNS = "dns"
+	// DiscoverySRV treats the sink's address as the name of an SRV record,
+	// e.g. _syslog._tcp.example.com, and connects to its targets.
+	DiscoverySRV = "srv"
+)
+
+// Resolver looks up the addresses of sinks that use discovery. It is
+// satisfied by *net.Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// resolution is the result of resolving a sink's address.
+type resolution struct {
+	addrs []string
+	// hosts maps every resolved address to the host name it was resolved
+	// from. It is used as the server name of TLS connections.
+	hosts map[string]string
+}
+
+func validateDiscovery(discovery, transport, addr string) error {
+	switch discovery {
+	case "":
+		return nil
+	case DiscoveryDNS:
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("dns discovery requires a host and port: %s", err)
+		}
+	case DiscoverySRV:
+		if addr == "" {
+			return fmt.Errorf("srv discovery requires a record name")
+		}
+	default:
+		return fmt.Errorf("unsupported discovery %q", discovery)
+	}
+
+	switch transport {
+	case "", TransportTCP, TransportUDP, TransportRELP:
+		return nil
+	}
+	return fmt.Errorf("transport %s does not support discovery", transport)
+}
+
+// loadResolution returns the sink's resolved addresses or nil if the sink
+// does not use discovery or was not resolved yet.
+func (s *Sink) loadResolution() *resolution {
+	r, _ := s.resolution.Load().(*resolution)
+	return r
+}
+
+// resolve looks up the sink's addresses. If they changed since the last
+// resolution the sink's connection is closed so that it reconnects to the
+// new addresses.
+func (s *Sink) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dialTimeout)
+	defer cancel()
+
+	var (
+		r   *resolution
+		err error
+	)
+	if s.Discovery == DiscoverySRV {
+		r, err = s.lookupSRV(ctx)
+	} else {
+		r, err = s.lookupHost(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if len(r.addrs) == 0 {
+		return fmt.Errorf("no addresses found for %s", s.Addr)
+	}
+
+	prev := s.loadResolution()
+	if prev != nil && sameAddrs(prev.addrs, r.addrs) {
+		return nil
+	}
+
+	s.resolution.Store(r)
+	atomic.StoreInt32(&s.activeAddrIndex, 0)
+	if prev == nil {
+		return nil
+	}
+
+	log.Printf("Sink to address %s, at namespace [%s] resolved to %s\n", s.Addr, s.Namespace, strings.Join(r.addrs, ", "))
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return nil
+}
+
+// ensureResolved resolves the addresses of a sink that uses discovery if
+// they were not resolved yet.
+func (s *Sink) ensureResolved() error {
+	if s.Discovery == "" || s.loadResolution() != nil {
+		return nil
+	}
+	return s.resolve()
+}
+
+// reresolve is called periodically to follow changes of the sink's
+// addresses.
+func (s *Sink) reresolve() {
+	err := s.resolve()
+	if err != nil {
+		log.Printf("Sink to address %s, at namespace [%s] failed to resolve: %s\n", s.Addr, s.Namespace, err)
+	}
+}
+
+func (s *Sink) lookupHost(ctx context.Context) (*resolution, error) {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &resolution{
+		hosts: make(map[string]string),
+	}
+	err = s.lookupInto(ctx, r, host, port)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(r.addrs)
+	return r, nil
+}
+
+// lookupSRV resolves the targets of the SRV record to their IP addresses.
+// The addresses are ordered by the priority of their records and, within a
+// priority, by descending weight. Unlike the weighted random selection of
+// RFC 2782 the order is stable so that the sink does not reconnect after
+// every resolution. Targets that fail to resolve are skipped unless none
+// resolve.
+func (s *Sink) lookupSRV(ctx context.Context) (*resolution, error) {
+	_, srvs, err := s.resolver.LookupSRV(ctx, "", "", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight > srvs[j].Weight
+	})
+
+	r := &resolution{
+		hosts: make(map[string]string),
+	}
+	var firstErr error
+	resolved := 0
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		err = s.lookupInto(ctx, r, host, strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			log.Printf("Sink to address %s, at namespace [%s] skipped SRV target %s: %s\n", s.Addr, s.Namespace, host, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		resolved++
+	}
+	if resolved == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return r, nil
+}
+
+func (s *Sink) lookupInto(ctx context.Context, r *resolution, host, port string) error {
+	ips, err := s.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip, port)
+		if _, ok := r.hosts[addr]; ok {
+			continue
+		}
+		r.addrs = append(r.addrs, addr)
+		r.hosts[addr] = host
+	}
+	return nil
+}
+
+// serverName returns the name used to verify the certificate of the TLS
+// server at addr.
+func (s *Sink) serverName(addr string) string {
+	if r := s.loadResolution(); r != nil {
+		if host, ok := r.hosts[addr]; ok {
+			return host
+		}
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
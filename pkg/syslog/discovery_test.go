@@ -0,0 +1,291 @@
+package syslog_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// fakeResolver resolves names from its hosts and srvs maps.
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		hosts: make(map[string][]string),
+		srvs:  make(map[string][]*net.SRV),
+	}
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host " + host)
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	srvs, ok := r.srvs[name]
+	if !ok {
+		return "", nil, errors.New("no such record " + name)
+	}
+	return name, srvs, nil
+}
+
+func (r *fakeResolver) setSRV(name string, srvs ...*net.SRV) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.srvs[name] = srvs
+}
+
+func (r *fakeResolver) setHost(host string, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = addrs
+}
+
+func port(addr string) uint16 {
+	_, p, err := net.SplitHostPort(addr)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	n, err := strconv.Atoi(p)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return uint16(n)
+}
+
+var _ = Describe("Discovery", func() {
+	var (
+		resolver *fakeResolver
+		record   func(msg string) map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		resolver = newFakeResolver()
+		resolver.setHost("collector-0.example.com", "127.0.0.1")
+		resolver.setHost("collector-1.example.com", "127.0.0.1")
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+				},
+			}
+		}
+	})
+
+	expectedMsg := func(msg string) string {
+		return `<14>1 1970-01-01T00:00:00+00:00 - pod.log/some-ns// - - [kubernetes@47450 namespace_name="some-ns" object_name="" container_name=""] ` + msg + "\n"
+	}
+
+	It("resolves the host of the address", func() {
+		spySink := newSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      net.JoinHostPort("collector-0.example.com", strconv.Itoa(int(port(spySink.url())))),
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoveryDNS,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		spySink.expectReceived(expectedMsg("some-log"))
+		Expect(out.SinkState()[0].ActiveAddr).To(Equal(spySink.url()))
+	})
+
+	It("connects to the targets of the SRV record in order of priority", func() {
+		primary := newSpySink("127.0.0.1:0")
+		defer primary.stop()
+		secondary := newSpySink("127.0.0.1:0")
+		defer secondary.stop()
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "collector-1.example.com.", Port: port(secondary.url()), Priority: 20},
+			&net.SRV{Target: "collector-0.example.com.", Port: port(primary.url()), Priority: 10},
+		)
+
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		primary.expectReceived(expectedMsg("some-log"))
+		Expect(out.SinkState()[0].ActiveAddr).To(Equal(primary.url()))
+	})
+
+	It("skips targets of the SRV record that fail to resolve", func() {
+		spySink := newSpySink("127.0.0.1:0")
+		defer spySink.stop()
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "missing.example.com.", Port: port(spySink.url()), Priority: 10},
+			&net.SRV{Target: "collector-1.example.com.", Port: port(spySink.url()), Priority: 20},
+		)
+
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		spySink.expectReceived(expectedMsg("some-log"))
+		Expect(out.SinkState()[0].Error).To(BeNil())
+	})
+
+	It("fails over to the next target of the SRV record", func() {
+		unreachable := newSpySink("127.0.0.1:0")
+		unreachable.stop()
+		secondary := newSpySink("127.0.0.1:0")
+		defer secondary.stop()
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "collector-0.example.com.", Port: port(unreachable.url()), Priority: 10},
+			&net.SRV{Target: "collector-1.example.com.", Port: port(secondary.url()), Priority: 20},
+		)
+
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		secondary.expectReceived(expectedMsg("some-log"))
+	})
+
+	It("reconnects when the resolved addresses change", func() {
+		first := newSpySink("127.0.0.1:0")
+		defer first.stop()
+		second := newSpySink("127.0.0.1:0")
+		defer second.stop()
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "collector-0.example.com.", Port: port(first.url())},
+		)
+
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithResolver(resolver),
+			syslog.WithResolveInterval(50*time.Millisecond),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		first.expectReceived(expectedMsg("log-1"))
+
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "collector-1.example.com.", Port: port(second.url())},
+		)
+		Eventually(func() string {
+			return out.SinkState()[0].ActiveAddr
+		}).Should(Equal(second.url()))
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+		second.expectReceived(expectedMsg("log-2"))
+	})
+
+	It("reports resolution failures as sink errors", func() {
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(Equal("no such record _syslog._tcp.example.com"))
+	})
+
+	It("verifies TLS servers by the name of the SRV target", func() {
+		serverNames := make(chan string, 1)
+		spySink := newTLSSpySinkWithConfig(&tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				serverNames <- hello.ServerName
+				return nil, nil
+			},
+		}, "127.0.0.1:0")
+		defer spySink.stop()
+		resolver.setHost("localhost", "127.0.0.1")
+		resolver.setSRV(
+			"_syslog._tcp.example.com",
+			&net.SRV{Target: "localhost.", Port: port(spySink.url())},
+		)
+
+		s := &syslog.Sink{
+			Addr:      "_syslog._tcp.example.com",
+			Namespace: "some-ns",
+			Discovery: syslog.DiscoverySRV,
+			TLS: &syslog.TLS{
+				RootCA: "./testdata/rootCA.crt",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil, syslog.WithResolver(resolver))
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+		}()
+
+		spySink.expectReceived(expectedMsg("some-log"))
+		Expect(serverNames).To(Receive(Equal("localhost")))
+	})
+
+	DescribeTable("validates the discovery", func(discovery, addr, transport string, valid bool) {
+		s := &syslog.Sink{
+			Addr:      addr,
+			Discovery: discovery,
+			Transport: transport,
+		}
+
+		err := s.Validate()
+
+		if valid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("dns", syslog.DiscoveryDNS, "collector.example.com:514", syslog.TransportTCP, true),
+		Entry("srv", syslog.DiscoverySRV, "_syslog._tcp.example.com", syslog.TransportRELP, true),
+		Entry("udp", syslog.DiscoveryDNS, "collector.example.com:514", syslog.TransportUDP, true),
+		Entry("dns without port", syslog.DiscoveryDNS, "collector.example.com", syslog.TransportTCP, false),
+		Entry("unsupported discovery", "mdns", "collector.example.com:514", syslog.TransportTCP, false),
+		Entry("unix", syslog.DiscoveryDNS, "collector.example.com:514", syslog.TransportUnix, false),
+		Entry("https", syslog.DiscoverySRV, "https://collector.example.com", syslog.TransportHTTPS, false),
+	)
+})
@@ -0,0 +1,554 @@
+package syslog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+)
+
+// Sync policies of a disk queue.
+const (
+	// DiskQueueSyncAlways syncs the segment file after every message.
+	DiskQueueSyncAlways = "always"
+	// DiskQueueSyncInterval syncs the segment file at most once a second.
+	DiskQueueSyncInterval = "interval"
+	// DiskQueueSyncNever leaves syncing the segment files to the operating
+	// system.
+	DiskQueueSyncNever = "never"
+)
+
+const (
+	defaultDiskQueueMaxBytes     = 256 << 20
+	defaultDiskQueueSegmentBytes = 16 << 20
+	diskQueueSyncInterval        = time.Second
+
+	// Records are prefixed with their length and CRC-32 checksum.
+	diskRecordHeaderSize = 8
+	segmentSuffix        = ".seg"
+	cursorFile           = "cursor"
+)
+
+var errDiskQueueFull = errors.New("disk queue is full")
+
+// DiskQueue configures the on-disk queue of a sink. Messages spill to the
+// disk queue when the sink's queue is full and are replayed from it once
+// the sink caught up, also after a restart.
+type DiskQueue struct {
+	// Dir is the directory of the queue's files. Every sink needs its own
+	// directory.
+	Dir string `json:"dir" yaml:"dir"`
+	// MaxBytes limits the size of the queue's files, 256 MiB by default.
+	// Messages are dropped when the queue is full.
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes"`
+	// SegmentBytes is the size at which a new segment file is started,
+	// 16 MiB by default. Segment files are removed once all of their
+	// messages were sent.
+	SegmentBytes int64 `json:"segment_bytes" yaml:"segment_bytes"`
+	// Sync is one of DiskQueueSyncAlways, DiskQueueSyncInterval (the
+	// default) or DiskQueueSyncNever.
+	Sync string `json:"sync" yaml:"sync"`
+}
+
+func validateDiskQueue(q *DiskQueue, workers int) error {
+	if q == nil {
+		return nil
+	}
+	if q.Dir == "" {
+		return errors.New("disk queue requires a directory")
+	}
+	if q.MaxBytes < 0 || q.SegmentBytes < 0 {
+		return errors.New("disk queue sizes must not be negative")
+	}
+	switch q.Sync {
+	case "", DiskQueueSyncAlways, DiskQueueSyncInterval, DiskQueueSyncNever:
+	default:
+		return fmt.Errorf("unsupported disk queue sync %q", q.Sync)
+	}
+	if workers > 1 {
+		return errors.New("sinks with workers do not support a disk queue")
+	}
+	return nil
+}
+
+// diskQueue is a queue of messages kept in segment files. Messages are
+// appended to the last segment and read from the first one. The position
+// of the next message to read is kept in the cursor file.
+type diskQueue struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	sync         string
+	notify       chan struct{}
+
+	mu       sync.Mutex
+	segments []uint64
+	w        *os.File
+	wSize    int64
+	r        *os.File
+	rOffset  int64
+	peeked   int64
+	cursor   *os.File
+	size     int64
+	pending  int64
+	synced   time.Time
+}
+
+// openDiskQueue opens the disk queue in the configured directory, creating
+// it if needed. Messages left in the queue are replayed.
+func openDiskQueue(c *DiskQueue) (*diskQueue, error) {
+	q := &diskQueue{
+		dir:          c.Dir,
+		maxBytes:     c.MaxBytes,
+		segmentBytes: c.SegmentBytes,
+		sync:         c.Sync,
+		notify:       make(chan struct{}, 1),
+	}
+	if q.maxBytes == 0 {
+		q.maxBytes = defaultDiskQueueMaxBytes
+	}
+	if q.segmentBytes == 0 {
+		q.segmentBytes = defaultDiskQueueSegmentBytes
+	}
+	if q.sync == "" {
+		q.sync = DiskQueueSyncInterval
+	}
+
+	err := os.MkdirAll(q.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = q.load()
+	if err != nil {
+		q.close()
+		return nil, err
+	}
+	if q.pending > 0 {
+		q.signal()
+	}
+	return q, nil
+}
+
+// load finds the segments and the cursor and checks all records that were
+// not read yet. Segments are truncated at the first damaged record, such
+// as one that was only partially written before a crash.
+func (q *diskQueue) load() error {
+	names, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range names {
+		name := fi.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, seq)
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i] < q.segments[j]
+	})
+
+	q.cursor, err = os.OpenFile(filepath.Join(q.dir, cursorFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	var buf [16]byte
+	n, _ := q.cursor.ReadAt(buf[:], 0)
+	var seq uint64
+	var offset int64
+	if n == len(buf) {
+		seq = binary.BigEndian.Uint64(buf[:8])
+		offset = int64(binary.BigEndian.Uint64(buf[8:]))
+	}
+
+	// Remove the segments that were read completely.
+	for len(q.segments) != 0 && q.segments[0] < seq {
+		err = os.Remove(q.segmentPath(q.segments[0]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		q.segments = q.segments[1:]
+	}
+	if len(q.segments) == 0 || q.segments[0] != seq {
+		offset = 0
+	}
+
+	for i, seq := range q.segments {
+		start := int64(0)
+		if i == 0 {
+			start = offset
+		}
+		size, records, err := q.check(seq, start)
+		if err != nil {
+			return err
+		}
+		if i == 0 && offset > size {
+			offset = size
+		}
+		q.size += size
+		q.pending += records
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, seq)
+		offset = 0
+	}
+	q.rOffset = offset
+	q.r, err = os.Open(q.segmentPath(q.segments[0]))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return q.openWriter()
+}
+
+// check counts the records of a segment after start and truncates it after
+// the last valid record.
+func (q *diskQueue) check(seq uint64, start int64) (int64, int64, error) {
+	f, err := os.OpenFile(q.segmentPath(seq), os.O_RDWR, 0600)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var records int64
+	offset := int64(0)
+	for {
+		data, err := readRecord(f, offset, fi.Size())
+		if err != nil {
+			break
+		}
+		if offset >= start {
+			records++
+		}
+		offset += diskRecordHeaderSize + int64(len(data))
+	}
+	if fi.Size() != offset {
+		log.Printf("Disk queue %s truncated damaged segment %s at %d bytes\n", q.dir, q.segmentPath(seq), offset)
+		err = f.Truncate(offset)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return offset, records, nil
+}
+
+func (q *diskQueue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// openWriter opens the last segment for appending.
+func (q *diskQueue) openWriter() error {
+	seq := q.segments[len(q.segments)-1]
+	w, err := os.OpenFile(q.segmentPath(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := w.Stat()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	q.w = w
+	q.wSize = fi.Size()
+	if q.r == nil && len(q.segments) == 1 {
+		q.r, err = os.Open(q.segmentPath(seq))
+	}
+	return err
+}
+
+// push appends a message to the queue. It must be called with mu held.
+func (q *diskQueue) push(data []byte) error {
+	n := diskRecordHeaderSize + int64(len(data))
+	if q.size+n > q.maxBytes {
+		return errDiskQueueFull
+	}
+	if q.wSize > 0 && q.wSize+n > q.segmentBytes {
+		err := q.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[diskRecordHeaderSize:], data)
+	_, err := q.w.Write(buf)
+	if err != nil {
+		return err
+	}
+	q.wSize += n
+	q.size += n
+	q.pending++
+
+	if q.sync == DiskQueueSyncAlways ||
+		(q.sync == DiskQueueSyncInterval && time.Since(q.synced) >= diskQueueSyncInterval) {
+		q.synced = time.Now()
+		_ = q.w.Sync()
+	}
+	q.signal()
+	return nil
+}
+
+// rotate starts a new segment.
+func (q *diskQueue) rotate() error {
+	if q.sync != DiskQueueSyncNever {
+		_ = q.w.Sync()
+	}
+	err := q.w.Close()
+	if err != nil {
+		return err
+	}
+	q.segments = append(q.segments, q.segments[len(q.segments)-1]+1)
+	return q.openWriter()
+}
+
+// peek returns the next message of the queue without removing it or nil if
+// the queue is empty.
+func (q *diskQueue) peek() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.pending > 0 {
+		data, err := readRecord(q.r, q.rOffset, q.maxBytes)
+		if err == nil {
+			q.peeked = diskRecordHeaderSize + int64(len(data))
+			return data, nil
+		}
+		if err != io.EOF || len(q.segments) == 1 {
+			return nil, err
+		}
+
+		// The first segment was read completely.
+		q.r.Close()
+		err = os.Remove(q.segmentPath(q.segments[0]))
+		if err != nil {
+			return nil, err
+		}
+		q.size -= q.rOffset
+		q.segments = q.segments[1:]
+		q.rOffset = 0
+		q.r, err = os.Open(q.segmentPath(q.segments[0]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// commit removes the message returned by peek from the queue.
+func (q *diskQueue) commit() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.rOffset += q.peeked
+	q.peeked = 0
+	q.pending--
+
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], q.segments[0])
+	binary.BigEndian.PutUint64(buf[8:], uint64(q.rOffset))
+	_, _ = q.cursor.WriteAt(buf[:], 0)
+	if q.sync == DiskQueueSyncAlways {
+		_ = q.cursor.Sync()
+	}
+}
+
+// accepting reports whether the queue has room for another message.
+func (q *diskQueue) accepting() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size < q.maxBytes
+}
+
+// room reports whether n more bytes fit into the queue. More bytes than
+// the queue holds fit once it is empty.
+func (q *diskQueue) room(n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size+n <= q.maxBytes || (n > q.maxBytes && q.size == 0)
+}
+
+// stats returns the number of messages and bytes in the queue.
+func (q *diskQueue) stats() (int64, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending, q.size
+}
+
+func (q *diskQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *diskQueue) close() {
+	for _, f := range []*os.File{q.w, q.r, q.cursor} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// readRecord reads the record at offset. It returns io.EOF if there is no
+// complete record at offset and an error if the record is damaged or
+// larger than max.
+func readRecord(f *os.File, offset, max int64) ([]byte, error) {
+	var header [diskRecordHeaderSize]byte
+	_, err := f.ReadAt(header[:], offset)
+	if err != nil {
+		return nil, io.EOF
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	if size > max {
+		return nil, errors.New("damaged disk queue record")
+	}
+	data := make([]byte, size)
+	_, err = f.ReadAt(data, offset+diskRecordHeaderSize)
+	if err != nil {
+		return nil, io.EOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("damaged disk queue record")
+	}
+	return data, nil
+}
+
+// spool adds msg to the sink's queue. If the queue is full, or messages are
+// already waiting in the disk queue, msg is added to the disk queue so that
+// the order of the messages is kept. It reports whether msg was queued.
+func (s *Sink) spool(msg io.WriterTo) bool {
+	q := s.diskQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == 0 {
+		select {
+		case s.messages <- msg:
+			return true
+		default:
+		}
+	}
+
+	m, ok := msg.(*rfc5424.Message)
+	if !ok {
+		s.dropQueued()
+		return false
+	}
+	data, err := m.MarshalBinary()
+	if err == nil {
+		err = q.push(data)
+	}
+	if err != nil {
+		if err != errDiskQueueFull {
+			log.Printf("Sink to address %s, at namespace [%s] failed to write to its disk queue: %s\n", s.Addr, s.Namespace, err)
+		}
+		s.dropQueued()
+		return false
+	}
+	return true
+}
+
+// replay writes the messages of the disk queue while the sink's queue is
+// empty and the sink is not holding a message. A message is removed from
+// the disk queue once it was sent or dropped, a held message stays in it.
+func (s *Sink) replay() {
+	q := s.diskQueue
+	for len(s.messages) == 0 && s.held == nil {
+		select {
+		case <-s.abort:
+			return
+		default:
+		}
+
+		data, err := q.peek()
+		if err != nil {
+			log.Printf("Sink to address %s, at namespace [%s] failed to read its disk queue: %s\n", s.Addr, s.Namespace, err)
+			s.storeError(fmt.Errorf("disk queue: %s", err))
+			return
+		}
+		if data == nil {
+			return
+		}
+
+		msg := &rfc5424.Message{}
+		err = msg.UnmarshalBinary(data)
+		if err != nil {
+			atomic.AddInt64(&s.messagesDropped, 1)
+		} else {
+			s.write(msg)
+			if s.held == msg {
+				s.heldOnDisk = true
+				return
+			}
+		}
+		q.commit()
+	}
+	q.signal()
+}
+
+// persist adds the messages the sink still keeps in memory to its disk
+// queue so that they are sent after a restart: the held message, the
+// messages batched behind it and the messages left in the sink's queue.
+// They are added after the messages already in the disk queue. persist
+// must only be called once the sink stopped.
+func (s *Sink) persist() {
+	var msgs []io.WriterTo
+	if s.held != nil && !s.heldOnDisk {
+		msgs = append(msgs, s.held)
+	}
+	if s.batchFor != nil {
+		msgs = append(msgs, s.batched...)
+		s.batchFor, s.batched = nil, nil
+	}
+	s.held = nil
+	for m := range s.messages {
+		msgs = append(msgs, m)
+	}
+
+	q := s.diskQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, msg := range msgs {
+		m, ok := msg.(*rfc5424.Message)
+		if !ok {
+			s.dropQueued()
+			continue
+		}
+		data, err := m.MarshalBinary()
+		if err == nil {
+			err = q.push(data)
+		}
+		if err != nil {
+			if err != errDiskQueueFull {
+				log.Printf("Sink to address %s, at namespace [%s] failed to write to its disk queue: %s\n", s.Addr, s.Namespace, err)
+			}
+			s.dropQueued()
+		}
+	}
+	if q.w != nil {
+		_ = q.w.Sync()
+	}
+}
@@ -0,0 +1,371 @@
+package syslog_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// gatedListener accepts connections that do not read until open is
+// closed.
+type gatedListener struct {
+	net.Listener
+	open chan struct{}
+}
+
+func (l gatedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return gatedConn{conn, l.open}, nil
+}
+
+type gatedConn struct {
+	net.Conn
+	open chan struct{}
+}
+
+func (c gatedConn) Read(b []byte) (int, error) {
+	<-c.open
+	return c.Conn.Read(b)
+}
+
+var _ = Describe("Disk queue", func() {
+	var (
+		dir    string
+		record func(msg string) map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "disk-queue")
+		Expect(err).ToNot(HaveOccurred())
+
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+					"pod_name":       []byte("some-pod"),
+				},
+			}
+		}
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	// unreachable returns an address nothing listens on.
+	unreachable := func() string {
+		c := newCollector()
+		c.stop()
+		return c.url()
+	}
+
+	It("spills messages to disk while the sink can not connect", func() {
+		addr := unreachable()
+		s := &syslog.Sink{
+			Addr:      addr,
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir: dir,
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBufferSize(2),
+			syslog.WithReconnectBackoff(50*time.Millisecond, 50*time.Millisecond),
+		)
+
+		var expected []string
+		for i := 0; i < 20; i++ {
+			msg := "log-" + strconv.Itoa(i)
+			expected = append(expected, msg+"\n")
+			out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(func() int64 {
+			return out.SinkState()[0].DiskQueueMessages
+		}).Should(BeNumerically(">=", 17))
+		Expect(out.SinkState()[0].DiskQueueBytes).To(BeNumerically(">", 0))
+		Expect(s.MessagesDropped()).To(BeZero())
+
+		c := newCollector(addr)
+		defer c.stop()
+
+		Eventually(c.received, 2*time.Second).Should(Equal(expected))
+		Eventually(func() int64 {
+			return out.SinkState()[0].DiskQueueMessages
+		}).Should(BeZero())
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("replays the messages left on disk when it starts", func() {
+		s := &syslog.Sink{
+			Addr:      unreachable(),
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir:          dir,
+				SegmentBytes: 512,
+				Sync:         syslog.DiskQueueSyncAlways,
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBufferSize(1),
+			syslog.WithReconnectBackoff(time.Hour, time.Hour),
+		)
+		out.Write(record("log-0"), time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(func() int64 {
+			return out.SinkState()[0].ConsecutiveFailures
+		}).Should(Equal(int64(1)))
+		for i := 1; i < 20; i++ {
+			out.Write(record("log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+		}
+		Expect(out.SinkState()[0].DiskQueueMessages).To(Equal(int64(18)))
+
+		segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(segments)).To(BeNumerically(">", 1))
+
+		// A message that was only partially written is discarded.
+		f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0600)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write([]byte{0, 0, 1, 0, 1, 2})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		c := newCollector()
+		defer c.stop()
+		restarted := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir:          dir,
+				SegmentBytes: 512,
+			},
+		}
+		out = syslog.NewOut([]*syslog.Sink{restarted}, nil)
+
+		var expected []string
+		for i := 2; i < 20; i++ {
+			expected = append(expected, "log-"+strconv.Itoa(i)+"\n")
+		}
+		Eventually(c.received).Should(Equal(expected))
+		Eventually(func() []string {
+			segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			return segments
+		}).Should(HaveLen(1))
+	})
+
+	Describe("when the sink is closed", func() {
+		var expected []string
+
+		// closeUnsent writes messages to a sink that can not connect and
+		// closes it before it sent any of them.
+		closeUnsent := func(addr string) {
+			s := &syslog.Sink{
+				Addr:      addr,
+				Namespace: "some-ns",
+				DiskQueue: &syslog.DiskQueue{Dir: dir},
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithReconnectBackoff(time.Hour, time.Hour),
+			)
+			expected = nil
+			for i := 0; i < 50; i++ {
+				msg := "log-" + strconv.Itoa(i)
+				expected = append(expected, msg+"\n")
+				out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+			}
+			EventuallyWithOffset(1, func() int64 {
+				return out.SinkState()[0].ConsecutiveFailures
+			}).Should(Equal(int64(1)))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			ExpectWithOffset(1, out.Close(ctx)).ToNot(Succeed())
+			ExpectWithOffset(1, s.MessagesDropped()).To(BeZero())
+		}
+
+		It("keeps the messages it did not send in the disk queue", func() {
+			closeUnsent(unreachable())
+
+			c := newCollector()
+			defer c.stop()
+			restarted := &syslog.Sink{
+				Addr:      c.url(),
+				Namespace: "some-ns",
+				DiskQueue: &syslog.DiskQueue{Dir: dir},
+			}
+			syslog.NewOut([]*syslog.Sink{restarted}, nil)
+
+			Eventually(c.received).Should(Equal(expected))
+		})
+
+		It("keeps a replayed message in the disk queue until it was sent", func() {
+			addr := unreachable()
+			closeUnsent(addr)
+
+			s := &syslog.Sink{
+				Addr:      addr,
+				Namespace: "some-ns",
+				DiskQueue: &syslog.DiskQueue{Dir: dir},
+			}
+			out := syslog.NewOut(
+				[]*syslog.Sink{s},
+				nil,
+				syslog.WithReconnectBackoff(time.Hour, time.Hour),
+			)
+			Eventually(func() int64 {
+				return out.SinkState()[0].ConsecutiveFailures
+			}).Should(Equal(int64(1)))
+			Consistently(func() int64 {
+				return out.SinkState()[0].DiskQueueMessages
+			}, 100*time.Millisecond).Should(Equal(int64(50)))
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(out.Close(ctx)).ToNot(Succeed())
+
+			c := newCollector(addr)
+			defer c.stop()
+			restarted := &syslog.Sink{
+				Addr:      addr,
+				Namespace: "some-ns",
+				DiskQueue: &syslog.DiskQueue{Dir: dir},
+			}
+			syslog.NewOut([]*syslog.Sink{restarted}, nil)
+
+			Eventually(c.received).Should(Equal(expected))
+			Consistently(c.received, 100*time.Millisecond).Should(Equal(expected))
+		})
+	})
+
+	It("drops messages when the disk queue is full", func() {
+		s := &syslog.Sink{
+			Addr:      unreachable(),
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir:      dir,
+				MaxBytes: 1024,
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBufferSize(1),
+			syslog.WithReconnectBackoff(time.Hour, time.Hour),
+		)
+
+		for i := 0; i < 100; i++ {
+			out.Write(record("log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(s.MessagesDropped).Should(BeNumerically(">", 0))
+		state := out.SinkState()[0]
+		Expect(state.DiskQueueBytes).To(BeNumerically("<=", 1024))
+		Expect(state.DiskQueueMessages + s.MessagesDropped()).To(BeNumerically(">=", 98))
+	})
+
+	It("hands the disk queue over to the sink replacing it on reload", func() {
+		addr := unreachable()
+		s := &syslog.Sink{
+			Name:      "spooled",
+			Addr:      addr,
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir: dir,
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithBufferSize(1),
+			syslog.WithWriteTimeout(10*time.Second),
+			syslog.WithReconnectBackoff(50*time.Millisecond, 50*time.Millisecond),
+		)
+
+		// The messages do not fit into the socket buffers so that the
+		// replaced sink is still replaying the disk queue when it is
+		// replaced.
+		padding := strings.Repeat("x", 16<<10)
+		var expected []string
+		for i := 0; i < 500; i++ {
+			msg := "log-" + strconv.Itoa(i) + padding
+			expected = append(expected, msg+"\n")
+			out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+		Eventually(func() int64 {
+			return out.SinkState()[0].DiskQueueMessages
+		}).Should(BeNumerically(">=", 490))
+
+		lis, err := net.Listen("tcp", addr)
+		Expect(err).ToNot(HaveOccurred())
+		open := make(chan struct{})
+		old := &collector{lis: gatedListener{lis, open}}
+		go old.serve()
+		defer old.stop()
+		Eventually(s.MessagesSent).ShouldNot(BeZero())
+		time.AfterFunc(500*time.Millisecond, func() { close(open) })
+
+		c := newCollector()
+		defer c.stop()
+		replaced := &syslog.Sink{
+			Name:      "spooled",
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			DiskQueue: &syslog.DiskQueue{
+				Dir: dir + "/",
+			},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_ = out.Reload(ctx, []*syslog.Sink{replaced}, nil)
+
+		received := func() []string {
+			return append(old.received(), c.received()...)
+		}
+		Eventually(received, 5*time.Second).Should(HaveLen(len(expected)))
+		Consistently(received, 200*time.Millisecond).Should(ConsistOf(expected))
+	})
+
+	DescribeTable("validates the disk queue", func(q *syslog.DiskQueue, workers int, valid bool) {
+		s := &syslog.Sink{
+			Addr:      "localhost:514",
+			DiskQueue: q,
+			Workers:   workers,
+		}
+
+		err := s.Validate()
+
+		if valid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("default settings", &syslog.DiskQueue{Dir: "/tmp/q"}, 0, true),
+		Entry("sync policy", &syslog.DiskQueue{Dir: "/tmp/q", Sync: syslog.DiskQueueSyncNever}, 0, true),
+		Entry("missing directory", &syslog.DiskQueue{}, 0, false),
+		Entry("negative size", &syslog.DiskQueue{Dir: "/tmp/q", MaxBytes: -1}, 0, false),
+		Entry("unsupported sync policy", &syslog.DiskQueue{Dir: "/tmp/q", Sync: "sometimes"}, 0, false),
+		Entry("workers", &syslog.DiskQueue{Dir: "/tmp/q"}, 2, false),
+	)
+})
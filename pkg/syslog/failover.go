@@ -0,0 +1,120 @@
+package syslog
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"sync/atomic"
+)
+
+// addrs returns the sink's addresses in order of preference. For sinks that
+// use discovery the resolved addresses take the place of Addr.
+func (s *Sink) addrs() []string {
+	primary := []string{s.Addr}
+	if r := s.loadResolution(); r != nil {
+		primary = r.addrs
+	}
+	addrs := make([]string, 0, len(primary)+len(s.FailoverAddrs))
+	addrs = append(addrs, primary...)
+	return append(addrs, s.FailoverAddrs...)
+}
+
+// activeAddr returns the address the sink currently delivers to.
+func (s *Sink) activeAddr() string {
+	addrs := s.addrs()
+	i := int(atomic.LoadInt32(&s.activeAddrIndex))
+	if i >= len(addrs) {
+		return s.Addr
+	}
+	return addrs[i]
+}
+
+func (s *Sink) setActiveAddr(i int) {
+	prev := s.activeAddr()
+	atomic.StoreInt32(&s.activeAddrIndex, int32(i))
+	if addr := s.activeAddr(); addr != prev {
+		log.Printf("Sink at namespace [%s] switched from address %s to %s\n", s.Namespace, prev, addr)
+	}
+}
+
+// failover calls try with each of the sink's addresses, starting with the
+// active one, until try succeeds. The address that succeeded becomes the
+// active address. The sink stays on a failover address until the primary
+// address is probed successfully, see probePrimary.
+func (s *Sink) failover(try func(addr string) error) error {
+	err := s.ensureResolved()
+	if err != nil {
+		return err
+	}
+
+	addrs := s.addrs()
+	active := int(atomic.LoadInt32(&s.activeAddrIndex))
+
+	for i := range addrs {
+		j := (active + i) % len(addrs)
+		err = try(addrs[j])
+		if err == nil {
+			s.setActiveAddr(j)
+			return nil
+		}
+	}
+	return err
+}
+
+// connect establishes a connection to the first reachable address of the
+// sink.
+func (s *Sink) connect(dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	var conn net.Conn
+	err := s.failover(func(addr string) error {
+		var err error
+		conn, err = dial(addr)
+		return err
+	})
+	return conn, err
+}
+
+// probePrimary checks whether the primary address of a sink that failed
+// over is reachable again. If it is, the sink's connection is closed so that
+// the next message is delivered to the primary address.
+func (s *Sink) probePrimary() {
+	if atomic.LoadInt32(&s.activeAddrIndex) == 0 {
+		return
+	}
+
+	network, addr := s.probeAddr()
+	conn, err := s.dial(network, addr, s.dialTimeout)
+	if err != nil {
+		return
+	}
+	conn.Close()
+
+	s.setActiveAddr(0)
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// probeAddr returns the network and address used to probe the primary
+// address of the sink.
+func (s *Sink) probeAddr() (string, string) {
+	addr := s.addrs()[0]
+	switch s.Transport {
+	case TransportUDP, TransportUnix, TransportUnixgram:
+		return s.Transport, addr
+	case TransportHTTPS:
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "tcp", addr
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		return "tcp", net.JoinHostPort(u.Hostname(), port)
+	}
+	return "tcp", addr
+}
@@ -0,0 +1,156 @@
+package syslog_test
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Failover", func() {
+	var record func(msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+				},
+			}
+		}
+	})
+
+	expectedMsg := func(msg string) string {
+		return `<14>1 1970-01-01T00:00:00+00:00 - pod.log/some-ns// - - [kubernetes@47450 namespace_name="some-ns" object_name="" container_name=""] ` + msg + "\n"
+	}
+
+	It("reports the address as active without failover addresses", func() {
+		spySink := newSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		Expect(out.SinkState()[0].ActiveAddr).To(Equal(spySink.url()))
+	})
+
+	It("fails over to the next address when the primary is unreachable", func() {
+		primary := newSpySink("127.0.0.1:0")
+		primary.stop()
+		unreachable := newSpySink("127.0.0.1:0")
+		unreachable.stop()
+		standby := newSpySink("127.0.0.1:0")
+		defer standby.stop()
+
+		s := &syslog.Sink{
+			Addr:          primary.url(),
+			FailoverAddrs: []string{unreachable.url(), standby.url()},
+			Namespace:     "some-ns",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		standby.expectReceived(expectedMsg("some-log"))
+		Expect(out.SinkState()[0].ActiveAddr).To(Equal(standby.url()))
+		Expect(s.MessagesDropped()).To(Equal(int64(0)))
+	})
+
+	It("reports the last error when every address is unreachable", func() {
+		primary := newSpySink("127.0.0.1:0")
+		primary.stop()
+		standby := newSpySink("127.0.0.1:0")
+		standby.stop()
+
+		s := &syslog.Sink{
+			Addr:          primary.url(),
+			FailoverAddrs: []string{standby.url()},
+			Namespace:     "some-ns",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		state := out.SinkState()[0]
+		Expect(state.Error.Msg).To(ContainSubstring(standby.url()))
+		Expect(state.ActiveAddr).To(Equal(primary.url()))
+	})
+
+	It("fails back to the primary once it is reachable again", func() {
+		primary := newSpySink("127.0.0.1:0")
+		primaryAddr := primary.url()
+		primary.stop()
+		standby := newSpySink("127.0.0.1:0")
+		defer standby.stop()
+
+		s := &syslog.Sink{
+			Addr:          primaryAddr,
+			FailoverAddrs: []string{standby.url()},
+			Namespace:     "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithFailbackInterval(50*time.Millisecond),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		standby.expectReceived(expectedMsg("log-1"))
+
+		// The sink stays on the standby while the primary is down.
+		Consistently(func() string {
+			return out.SinkState()[0].ActiveAddr
+		}, 200*time.Millisecond).Should(Equal(standby.url()))
+
+		primary = newSpySink(primaryAddr)
+		defer primary.stop()
+
+		Eventually(func() string {
+			return out.SinkState()[0].ActiveAddr
+		}).Should(Equal(primaryAddr))
+		// Discard the connection of the successful probe.
+		Expect(primary.accept().Close()).To(Succeed())
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+		primary.expectReceived(expectedMsg("log-2"))
+	})
+
+	It("fails over https drains", func() {
+		primary := httptest.NewServer(&spyDrain{})
+		primary.Close()
+		drain := &spyDrain{}
+		standby := httptest.NewServer(drain)
+		defer standby.Close()
+
+		s := &syslog.Sink{
+			Addr:          primary.URL,
+			FailoverAddrs: []string{standby.URL},
+			Namespace:     "some-ns",
+			Transport:     syslog.TransportHTTPS,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(drain.received).Should(Equal([][]string{{"some-log\n"}}))
+		Expect(out.SinkState()[0].ActiveAddr).To(Equal(standby.URL))
+	})
+
+	It("validates the failover addresses of https drains", func() {
+		s := &syslog.Sink{
+			Addr:          "https://localhost/drain",
+			FailoverAddrs: []string{"localhost:514"},
+			Transport:     syslog.TransportHTTPS,
+		}
+
+		Expect(s.Validate()).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,64 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Framing methods for stream transports as defined in RFC 6587. An empty
+// framing is treated as octet counting.
+const (
+	FramingOctetCounting = "octet-counting"
+	FramingLF            = "lf"
+	FramingCRLF          = "crlf"
+	FramingNUL           = "nul"
+)
+
+// framingTrailers maps non-transparent framing methods to the trailer that
+// terminates each message.
+var framingTrailers = map[string][]byte{
+	FramingLF:   []byte("\n"),
+	FramingCRLF: []byte("\r\n"),
+	FramingNUL:  []byte("\x00"),
+}
+
+// framingEscapes maps characters that would be mistaken for a trailer to
+// their escaped form. The escapes follow the octal notation used by rsyslog
+// for control characters.
+var framingEscapes = map[byte][]byte{
+	'\n':   []byte("#012"),
+	'\x00': []byte("#000"),
+}
+
+func validateFraming(framing, transport string) error {
+	switch framing {
+	case "", FramingOctetCounting:
+		return nil
+	case FramingLF, FramingCRLF, FramingNUL:
+		switch transport {
+		case "", TransportTCP, TransportUnix, TransportHTTPS:
+			return nil
+		}
+		return fmt.Errorf("transport %s does not support %s framing", transport, framing)
+	}
+	return fmt.Errorf("unsupported framing %q", framing)
+}
+
+// frame delimits a marshaled message according to the sink's framing.
+// Messages are octet counted as defined in RFC 6587 unless the sink uses
+// non-transparent framing. With non-transparent framing the trailing newline
+// added to every log line is removed and any occurrence of the trailer's
+// last character within the message is escaped so that the receiver does
+// not split the message.
+func (s *Sink) frame(b []byte) []byte {
+	trailer, ok := framingTrailers[s.Framing]
+	if !ok {
+		return append([]byte(strconv.Itoa(len(b))+" "), b...)
+	}
+
+	special := trailer[len(trailer)-1]
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	b = bytes.Replace(b, []byte{special}, framingEscapes[special], -1)
+	return append(b, trailer...)
+}
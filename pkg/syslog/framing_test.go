@@ -0,0 +1,111 @@
+package syslog_test
+
+import (
+	"bufio"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Framing", func() {
+	DescribeTable(
+		"writes non-transparently framed messages",
+		func(framing string, delim byte, expected string) {
+			spySink := newSpySink()
+			defer spySink.stop()
+
+			s := &syslog.Sink{
+				Addr:      spySink.url(),
+				Namespace: "ns1",
+				Framing:   framing,
+			}
+			out := syslog.NewOut([]*syslog.Sink{s}, nil)
+			record := map[interface{}]interface{}{
+				"log": []byte("line-1\nline-2\x00\n"),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("ns1"),
+				},
+			}
+
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+			conn := spySink.accept()
+			defer conn.Close()
+			buf := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				actual, err := buf.ReadString(delim)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			}
+		},
+		Entry(
+			"LF",
+			syslog.FramingLF,
+			byte('\n'),
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1// - - [kubernetes@47450 namespace_name="ns1" object_name="" container_name=""] line-1#012line-2`+"\x00\n",
+		),
+		Entry(
+			"CRLF",
+			syslog.FramingCRLF,
+			byte('\n'),
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1// - - [kubernetes@47450 namespace_name="ns1" object_name="" container_name=""] line-1#012line-2`+"\x00\r\n",
+		),
+		Entry(
+			"NUL",
+			syslog.FramingNUL,
+			byte('\x00'),
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1// - - [kubernetes@47450 namespace_name="ns1" object_name="" container_name=""] line-1`+"\nline-2#000\x00",
+		),
+	)
+
+	It("uses octet counting when configured explicitly", func() {
+		spySink := newSpySink()
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "ns1",
+			Framing:   syslog.FramingOctetCounting,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+		record := map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("ns1"),
+			},
+		}
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		spySink.expectReceived(
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1// - - [kubernetes@47450 namespace_name="ns1" object_name="" container_name=""] some-log` + "\n",
+		)
+	})
+
+	DescribeTable(
+		"validates the framing",
+		func(transport, framing, expectedErr string) {
+			s := &syslog.Sink{
+				Transport: transport,
+				Framing:   framing,
+			}
+			err := s.Validate()
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("tcp with lf", "", syslog.FramingLF, ""),
+		Entry("unix with nul", syslog.TransportUnix, syslog.FramingNUL, ""),
+		Entry("udp with octet counting", syslog.TransportUDP, syslog.FramingOctetCounting, ""),
+		Entry("udp with lf", syslog.TransportUDP, syslog.FramingLF, "transport udp does not support lf framing"),
+		Entry("relp with crlf", syslog.TransportRELP, syslog.FramingCRLF, "transport relp does not support crlf framing"),
+		Entry("unknown framing", "", "smoke-signals", `unsupported framing "smoke-signals"`),
+	)
+})
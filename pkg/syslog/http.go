@@ -0,0 +1,193 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	httpMaxAttempts    = 3
+	httpInitialBackoff = 100 * time.Millisecond
+)
+
+// httpClient sends messages to an HTTPS syslog drain. Messages that are
+// queued when a request is made are sent together in a single POST body,
+// each framed according to the sink's framing.
+type httpClient struct {
+	sink      *Sink
+	out       *Out
+	batchSize int
+
+	client  *http.Client
+	checked time.Time
+	// body is the request body of the sink's batch.
+	body bytes.Buffer
+}
+
+func newHTTPClient(s *Sink, out *Out) *httpClient {
+	return &httpClient{
+		sink:      s,
+		out:       out,
+		batchSize: out.httpBatchSize,
+	}
+}
+
+func validateDrainURL(addr string, t *TLS) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid https drain url: %s", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("https drain url %q must be an absolute http or https url", addr)
+	}
+	if u.Scheme == "http" && t != nil {
+		return fmt.Errorf("https drain url %q does not support TLS", addr)
+	}
+	return nil
+}
+
+// maintainClient creates the HTTP client. The client of a TLS sink is
+// recreated when the CA or client certificate files changed.
+func (c *httpClient) maintainClient() error {
+	if c.client != nil {
+		if c.sink.TLS == nil ||
+			c.sink.tlsReloadInterval <= 0 ||
+			time.Since(c.checked) < c.sink.tlsReloadInterval {
+			return nil
+		}
+		c.checked = time.Now()
+		if tlsFingerprint(c.sink.TLS) == c.sink.tlsFingerprint {
+			return nil
+		}
+		c.client.CloseIdleConnections()
+		c.client = nil
+	}
+
+	transport := &http.Transport{
+		DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+			return c.sink.dial(network, addr, c.sink.dialTimeout)
+		},
+		TLSHandshakeTimeout: c.sink.dialTimeout,
+	}
+	if c.sink.TLS != nil {
+		config, err := c.sink.tlsConfig()
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = config
+	}
+
+	c.client = &http.Client{
+		Transport: transport,
+		Timeout:   c.sink.dialTimeout + c.sink.writeTimeout,
+	}
+	c.checked = time.Now()
+	return nil
+}
+
+// close closes the idle connections of the client.
+func (c *httpClient) close() {
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
+}
+
+// send posts the message together with the messages queued behind it.
+// Requests that fail with a network error, a 5xx or a 429 status are
+// retried with exponential backoff. If all attempts fail the batch is kept
+// and posted again when the message is sent again, or dropped together
+// with the message.
+func (c *httpClient) send(w io.WriterTo) error {
+	s := c.sink
+	if s.batchFor == nil || s.batchFor != w {
+		b, err := s.marshal(w)
+		if err != nil {
+			return err
+		}
+		c.body.Reset()
+		c.body.Write(s.frame(b))
+		s.batchFor, s.batched = w, nil
+
+	batch:
+		for len(s.batched)+1 < c.batchSize {
+			select {
+			case m, ok := <-s.messages:
+				if !ok {
+					break batch
+				}
+				b, err := s.marshal(m)
+				if err != nil {
+					atomic.AddInt64(&s.messagesDropped, 1)
+					continue
+				}
+				c.body.Write(s.frame(b))
+				s.batched = append(s.batched, m)
+			default:
+				break batch
+			}
+		}
+	}
+
+	var err error
+	backoff := httpInitialBackoff
+	for attempt := 1; ; attempt++ {
+		var (
+			retry   bool
+			postErr error
+		)
+		err = c.sink.failover(func(addr string) error {
+			retry, postErr = c.post(addr, c.body.Bytes())
+			if _, ok := postErr.(*url.Error); ok {
+				// The drain is unreachable, try the next address.
+				return postErr
+			}
+			return nil
+		})
+		if err == nil {
+			err = postErr
+		}
+		if err == nil {
+			// The message passed to send is counted as sent by the
+			// caller.
+			atomic.AddInt64(&s.messagesSent, int64(len(s.batched)))
+			s.batchFor, s.batched = nil, nil
+			return nil
+		}
+		if !retry || attempt == httpMaxAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return err
+}
+
+func (c *httpClient) post(addr string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, addr, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("https drain responded with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
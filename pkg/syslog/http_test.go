@@ -0,0 +1,232 @@
+package syslog_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// spyDrain is an HTTPS syslog drain that records the messages of every
+// request and responds with the queued status codes.
+type spyDrain struct {
+	mu       sync.Mutex
+	requests [][]string
+	statuses []int
+	block    chan struct{}
+}
+
+func (d *spyDrain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer GinkgoRecover()
+	Expect(r.Method).To(Equal(http.MethodPost))
+	Expect(r.Header.Get("Content-Type")).To(Equal("text/plain"))
+
+	if d.block != nil {
+		<-d.block
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	Expect(err).ToNot(HaveOccurred())
+
+	var msgs []string
+	buf := bytes.NewBuffer(body)
+	for buf.Len() > 0 {
+		var msg rfc5424.Message
+		_, err := msg.ReadFrom(buf)
+		Expect(err).ToNot(HaveOccurred())
+		msgs = append(msgs, string(msg.Message))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, msgs)
+	if len(d.statuses) != 0 {
+		w.WriteHeader(d.statuses[0])
+		d.statuses = d.statuses[1:]
+	}
+}
+
+func (d *spyDrain) received() [][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([][]string(nil), d.requests...)
+}
+
+var _ = Describe("HTTPS", func() {
+	var (
+		drain  *spyDrain
+		record func(msg string) map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		drain = &spyDrain{}
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("ns1"),
+				},
+			}
+		}
+	})
+
+	It("posts messages to the drain", func() {
+		server := httptest.NewTLSServer(drain)
+		defer server.Close()
+
+		s := &syslog.Sink{
+			Addr:      server.URL + "/drain",
+			Namespace: "ns1",
+			Transport: syslog.TransportHTTPS,
+			TLS: &syslog.TLS{
+				InsecureSkipVerify: true,
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(drain.received).Should(Equal([][]string{{"some-log\n"}}))
+		Expect(out.SinkState()[0].Error).To(BeNil())
+	})
+
+	It("batches queued messages into a single request", func() {
+		drain.block = make(chan struct{})
+		server := httptest.NewServer(drain)
+		defer server.Close()
+
+		s := &syslog.Sink{
+			Addr:      server.URL,
+			Namespace: "ns1",
+			Transport: syslog.TransportHTTPS,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithHTTPBatchSize(3),
+			syslog.WithWriteTimeout(5*time.Second),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		time.Sleep(100 * time.Millisecond)
+		for _, msg := range []string{"log-2", "log-3", "log-4", "log-5"} {
+			out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+		close(drain.block)
+
+		Eventually(drain.received).Should(Equal([][]string{
+			{"log-1\n"},
+			{"log-2\n", "log-3\n", "log-4\n"},
+			{"log-5\n"},
+		}))
+		Eventually(s.MessagesSent).Should(Equal(int64(5)))
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("retries requests that fail with a server error", func() {
+		drain.statuses = []int{http.StatusServiceUnavailable, http.StatusOK}
+		server := httptest.NewServer(drain)
+		defer server.Close()
+
+		s := &syslog.Sink{
+			Addr:      server.URL,
+			Namespace: "ns1",
+			Transport: syslog.TransportHTTPS,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(drain.received).Should(HaveLen(2))
+		Consistently(s.MessagesDropped).Should(BeZero())
+		Expect(out.SinkState()[0].Error).To(BeNil())
+	})
+
+	It("posts the whole batch again when a sink with a disk queue holds it", func() {
+		dir, err := ioutil.TempDir("", "http")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		drain.block = make(chan struct{})
+		drain.statuses = []int{http.StatusOK}
+		for i := 0; i < 6; i++ {
+			drain.statuses = append(drain.statuses, http.StatusInternalServerError)
+		}
+		server := httptest.NewServer(drain)
+		defer server.Close()
+
+		s := &syslog.Sink{
+			Addr:      server.URL,
+			Namespace: "ns1",
+			Transport: syslog.TransportHTTPS,
+			DiskQueue: &syslog.DiskQueue{Dir: dir},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithReconnectBackoff(10*time.Millisecond, 10*time.Millisecond),
+			syslog.WithWriteTimeout(5*time.Second),
+		)
+
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		time.Sleep(100 * time.Millisecond)
+		for _, msg := range []string{"log-2", "log-3", "log-4", "log-5"} {
+			out.Write(record(msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+		close(drain.block)
+
+		Eventually(drain.received, 5*time.Second).Should(HaveLen(8))
+		Expect(drain.received()[7]).To(Equal([]string{"log-2\n", "log-3\n", "log-4\n", "log-5\n"}))
+		Eventually(s.MessagesSent).Should(Equal(int64(5)))
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("treats non-2xx responses as sink errors", func() {
+		drain.statuses = []int{http.StatusBadRequest}
+		server := httptest.NewServer(drain)
+		defer server.Close()
+
+		s := &syslog.Sink{
+			Addr:      server.URL,
+			Namespace: "ns1",
+			Transport: syslog.TransportHTTPS,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(Equal("https drain responded with status 400"))
+		Expect(drain.received()).To(HaveLen(1))
+	})
+
+	DescribeTable(
+		"validates the drain url",
+		func(addr string, t *syslog.TLS, expectedErr string) {
+			s := &syslog.Sink{
+				Addr:      addr,
+				Transport: syslog.TransportHTTPS,
+				TLS:       t,
+			}
+			err := s.Validate()
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("https url", "https://logs.example.com/drain", &syslog.TLS{}, ""),
+		Entry("http url", "http://logs.example.com/drain", nil, ""),
+		Entry("host and port", "logs.example.com:443", nil, `https drain url "logs.example.com:443" must be an absolute http or https url`),
+		Entry("http url with tls", "http://logs.example.com", &syslog.TLS{}, `https drain url "http://logs.example.com" does not support TLS`),
+	)
+})
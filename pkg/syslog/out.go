@@ -2,15 +2,16 @@ package syslog
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"encoding"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,18 +26,52 @@ const (
 	logPrefix   = "pod.log"
 )
 
+// Transports supported by a Sink. An empty transport is treated as TCP.
+const (
+	TransportTCP      = "tcp"
+	TransportUDP      = "udp"
+	TransportUnix     = "unix"
+	TransportUnixgram = "unixgram"
+	TransportRELP     = "relp"
+	TransportHTTPS    = "https"
+)
+
 var invalidHostnameCharacter = regexp.MustCompile(`[^a-z0-9-]`)
 
 type SinkError struct {
 	Msg       string    `json:"msg"`
 	Timestamp time.Time `json:"timestamp"`
+	// Proxy is set if the error occurred while connecting through the
+	// sink's proxy rather than to the sink itself.
+	Proxy bool `json:"proxy"`
 }
 
 type SinkState struct {
-	Name               string     `json:"name"`
-	Namespace          string     `json:"namespace"`
-	LastSuccessfulSend time.Time  `json:"last_successful_send"`
-	Error              *SinkError `json:"error"`
+	Name                string     `json:"name"`
+	Namespace           string     `json:"namespace"`
+	LastSuccessfulSend  time.Time  `json:"last_successful_send"`
+	Error               *SinkError `json:"error"`
+	ClientCertExpiry    *time.Time `json:"client_cert_expiry"`
+	RootCAExpiry        *time.Time `json:"root_ca_expiry"`
+	ActiveAddr          string     `json:"active_addr"`
+	NextRetry           *time.Time `json:"next_retry"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+	DiskQueueMessages   int64      `json:"disk_queue_messages,omitempty"`
+	DiskQueueBytes      int64      `json:"disk_queue_bytes,omitempty"`
+	MessagesTruncated   int64      `json:"messages_truncated,omitempty"`
+	// Connections is set for sinks with more than one connection.
+	Connections []ConnectionState `json:"connections,omitempty"`
+}
+
+// ConnectionState is the state of one of the connections of a sink.
+type ConnectionState struct {
+	Addr                string     `json:"addr"`
+	Connected           bool       `json:"connected"`
+	LastSuccessfulSend  *time.Time `json:"last_successful_send,omitempty"`
+	NextRetry           *time.Time `json:"next_retry,omitempty"`
+	ConsecutiveFailures int64      `json:"consecutive_failures,omitempty"`
+	EjectedUntil        *time.Time `json:"ejected_until"`
+	Error               *SinkError `json:"error"`
 }
 
 type Sink struct {
@@ -44,32 +79,118 @@ type Sink struct {
 	Name      string
 	Namespace string
 	TLS       *TLS
+	Transport string
+	Framing   string
+	Proxy     string
+	// FailoverAddrs are tried in order when Addr is unreachable.
+	FailoverAddrs []string
+	// Discovery is either empty or one of DiscoveryDNS or DiscoverySRV.
+	Discovery string
+	// LoadBalancing is either empty or one of LoadBalancingRoundRobin or
+	// LoadBalancingHash. Load balanced sinks spread their messages across
+	// all of their addresses instead of failing over.
+	LoadBalancing string
+	// Workers is the number of connections the sink delivers messages on
+	// concurrently.
+	Workers int
+	// ShardByPod makes all messages of a pod go through the same worker so
+	// that their order is preserved.
+	ShardByPod bool
+	// Required makes Write and WriteChunk refuse messages the sink can not
+	// queue if Out has backpressure. Other sinks drop them.
+	Required bool
+	// DiskQueue makes the sink spill messages to disk when its queue is
+	// full.
+	DiskQueue *DiskQueue
+	Format    string
+	// DialTimeout, WriteTimeout and BufferSize override the settings of
+	// the Out for this sink if they are set.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	BufferSize   int
 
-	messages chan io.WriterTo
-
-	messagesDropped      int64
-	lastSendSuccessNanos int64
-	lastSendAttemptNanos int64
-	writeErr             atomic.Value
+	messages  chan io.WriterTo
+	diskQueue *diskQueue
+	// held is the message the sink sends once it can connect again. Only
+	// sinks with a disk queue hold messages instead of dropping them.
+	// heldOnDisk reports whether held was replayed from the disk queue,
+	// which it is only removed from once it was sent or dropped.
+	held       io.WriterTo
+	heldOnDisk bool
+	// batchFor is the message whose write batch failed to write and
+	// batched are the messages in that batch behind it. The batch is
+	// written again when the message is.
+	batchFor io.WriterTo
+	batched  []io.WriterTo
 
-	conn               net.Conn
-	writeTimeout       time.Duration
-	maintainConnection func() error
-}
+	messagesDropped       int64
+	messagesTruncated     int64
+	lastSendSuccessNanos  int64
+	lastSendAttemptNanos  int64
+	clientCertExpiryNanos int64
+	rootCAExpiryNanos     int64
+	nextRetryNanos        int64
+	messagesRetried       int64
+	messagesSent          int64
+	consecutiveFailures   int64
+	writeErr              atomic.Value
+	resolution            atomic.Value
 
-type TLS struct {
-	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
-	RootCA             string `json:"root_ca"`
+	conn                net.Conn
+	writeTimeout        time.Duration
+	bufferSize          int
+	maxDatagramSize     int
+	tlsReloadInterval   time.Duration
+	tlsFingerprint      string
+	activeAddrIndex     int32
+	connected           int32
+	workers             []*Sink
+	dialTimeout         time.Duration
+	failbackInterval    time.Duration
+	resolver            Resolver
+	resolveInterval     time.Duration
+	minReconnectBackoff time.Duration
+	maxReconnectBackoff time.Duration
+	retryAttempts       int
+	retryMaxAge         time.Duration
+	done                chan struct{}
+	abort               chan struct{}
+	maintainConnection  func() error
+	send                func(io.WriterTo) error
+	connections         func() []ConnectionState
+	disconnect          func()
 }
 
 // Out writes fluentbit messages via syslog TCP (RFC 5424 and RFC 6587).
 type Out struct {
-	sinks        map[string][]*Sink
-	clusterSinks []*Sink
-	dialTimeout  time.Duration
-	bufferSize   int
-	writeTimeout time.Duration
-	sanitizeHost bool
+	sinks               map[string][]*Sink
+	clusterSinks        []*Sink
+	dialTimeout         time.Duration
+	bufferSize          int
+	writeTimeout        time.Duration
+	maxDatagramSize     int
+	relpWindowSize      int
+	httpBatchSize       int
+	tlsReloadInterval   time.Duration
+	failbackInterval    time.Duration
+	resolver            Resolver
+	resolveInterval     time.Duration
+	ejectionDuration    time.Duration
+	minReconnectBackoff time.Duration
+	maxReconnectBackoff time.Duration
+	retryAttempts       int
+	retryMaxAge         time.Duration
+	batchBytes          int
+	batchLinger         time.Duration
+	backpressure        bool
+	sanitizeHost        bool
+
+	mu          sync.RWMutex
+	closed      bool
+	reloadState *ReloadState
+	// chunkMu keeps chunks from filling the queues between checking and
+	// queueing their records.
+	chunkMu sync.Mutex
 }
 
 // OutOption is the optional setting of write output.
@@ -96,6 +217,109 @@ func WithWriteTimeout(t time.Duration) OutOption {
 	}
 }
 
+// WithMaxDatagramSize configures the maximum size of a datagram sent by UDP
+// and unixgram sinks. Larger messages are truncated.
+func WithMaxDatagramSize(s int) OutOption {
+	return func(o *Out) {
+		o.maxDatagramSize = s
+	}
+}
+
+// WithRELPWindowSize configures the number of messages a RELP sink sends
+// without having received an acknowledgement.
+func WithRELPWindowSize(s int) OutOption {
+	return func(o *Out) {
+		o.relpWindowSize = s
+	}
+}
+
+// WithHTTPBatchSize configures the maximum number of queued messages an
+// HTTPS sink sends in a single request.
+func WithHTTPBatchSize(s int) OutOption {
+	return func(o *Out) {
+		o.httpBatchSize = s
+	}
+}
+
+// WithWriteBatch configures stream sinks to write the messages in their
+// queue together with a single write of up to size bytes. If the queue is
+// empty a sink waits up to linger for more messages before it writes the
+// batch. A zero size disables batching.
+func WithWriteBatch(size int, linger time.Duration) OutOption {
+	return func(o *Out) {
+		o.batchBytes = size
+		o.batchLinger = linger
+	}
+}
+
+// WithTLSReloadInterval configures how often TLS sinks check whether their
+// CA and client certificate files changed. A sink with an established
+// connection reconnects with the new files once they changed. A zero
+// interval disables the check.
+func WithTLSReloadInterval(d time.Duration) OutOption {
+	return func(o *Out) {
+		o.tlsReloadInterval = d
+	}
+}
+
+// WithFailbackInterval configures how often a sink that failed over to one
+// of its FailoverAddrs probes its primary address. The sink switches back to
+// the primary address once it is reachable again. A zero interval disables
+// the probe.
+func WithFailbackInterval(d time.Duration) OutOption {
+	return func(o *Out) {
+		o.failbackInterval = d
+	}
+}
+
+// WithResolver configures the resolver used to look up the addresses of
+// sinks that use discovery.
+func WithResolver(r Resolver) OutOption {
+	return func(o *Out) {
+		o.resolver = r
+	}
+}
+
+// WithResolveInterval configures how often sinks that use discovery
+// resolve their addresses again. A sink reconnects when its addresses
+// changed. A zero interval disables re-resolution.
+func WithResolveInterval(d time.Duration) OutOption {
+	return func(o *Out) {
+		o.resolveInterval = d
+	}
+}
+
+// WithEjectionDuration configures for how long an address of a load
+// balanced sink receives no messages after it failed.
+func WithEjectionDuration(d time.Duration) OutOption {
+	return func(o *Out) {
+		o.ejectionDuration = d
+	}
+}
+
+// WithReconnectBackoff configures how long a sink waits before it tries to
+// connect again after failing to connect. The wait starts at min and
+// doubles with every consecutive failure up to max. Messages written while
+// the sink waits are dropped. A zero min disables the backoff. A max below
+// min is treated as min.
+func WithReconnectBackoff(min, max time.Duration) OutOption {
+	return func(o *Out) {
+		o.minReconnectBackoff = min
+		o.maxReconnectBackoff = max
+	}
+}
+
+// WithRetry configures how often a message that failed to send is sent
+// again on a new connection before it is dropped. A message is not retried
+// more than maxAge after it was first sent, unless maxAge is zero. Zero
+// attempts disable retries.
+func WithRetry(attempts int, maxAge time.Duration) OutOption {
+	return func(o *Out) {
+		o.retryAttempts = attempts
+		o.retryMaxAge = maxAge
+	}
+}
+
 // WithSanitizeHost configures hostname sanitization to conform to DNS
 // requirements.
 func WithSanitizeHost(s bool) OutOption {
@@ -104,12 +328,25 @@ func WithSanitizeHost(s bool) OutOption {
 	}
 }
 
-// NewOut returns a new Out which handles both tcp and tls connections.
+// NewOut returns a new Out which handles tcp, tls, udp, unix socket, relp
+// and https connections.
 func NewOut(sinks, clusterSinks []*Sink, opts ...OutOption) *Out {
 	out := &Out{
-		dialTimeout:  5 * time.Second,
-		bufferSize:   10000,
-		writeTimeout: time.Second,
+		dialTimeout:         5 * time.Second,
+		bufferSize:          10000,
+		writeTimeout:        time.Second,
+		maxDatagramSize:     2048,
+		relpWindowSize:      128,
+		httpBatchSize:       100,
+		tlsReloadInterval:   time.Minute,
+		failbackInterval:    30 * time.Second,
+		resolver:            net.DefaultResolver,
+		resolveInterval:     30 * time.Second,
+		ejectionDuration:    30 * time.Second,
+		minReconnectBackoff: 500 * time.Millisecond,
+		maxReconnectBackoff: 30 * time.Second,
+		retryAttempts:       3,
+		retryMaxAge:         10 * time.Second,
 	}
 
 	for _, o := range opts {
@@ -118,24 +355,11 @@ func NewOut(sinks, clusterSinks []*Sink, opts ...OutOption) *Out {
 
 	m := make(map[string][]*Sink)
 	for _, s := range sinks {
-		if s.TLS != nil {
-			s.maintainConnection = tlsMaintainConn(s, out)
-		} else {
-			s.maintainConnection = tcpMaintainConn(s, out)
-		}
-
 		m[s.Namespace] = append(m[s.Namespace], s)
-		s.writeTimeout = out.writeTimeout
-		s.start(out.bufferSize)
+		out.startSink(s)
 	}
 	for _, s := range clusterSinks {
-		if s.TLS != nil {
-			s.maintainConnection = tlsMaintainConn(s, out)
-		} else {
-			s.maintainConnection = tcpMaintainConn(s, out)
-		}
-		s.writeTimeout = out.writeTimeout
-		s.start(out.bufferSize)
+		out.startSink(s)
 	}
 	out.sinks = m
 	out.clusterSinks = clusterSinks
@@ -145,57 +369,171 @@ func NewOut(sinks, clusterSinks []*Sink, opts ...OutOption) *Out {
 // Write takes a record, timestamp, and tag, converts it into a syslog message
 // and routes it to the connections with the matching namespace.
 // Each sink has it's own backing network connection and queue. The queue's
-// size is fixed to 10000 messages. It will report dropped messages via a log
-// for every 1000 messages dropped.
+// size is 10000 messages unless configured otherwise. It will report
+// dropped messages via a log for every 1000 messages dropped.
 // If no connection is established one will be established per sink upon a
 // Write operation. Write will also write all messages to all cluster sinks
 // provided.
+// Write reports whether the message was queued by all of its sinks. Once
+// Out is closed no messages are queued. With
+// backpressure the message is not queued at all if any of its required
+// sinks is full or down.
 func (o *Out) Write(
 	record map[interface{}]interface{},
 	ts time.Time,
 	tag string,
-) {
-	msg, namespace := convert(record, ts, tag, o.sanitizeHost)
+) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.closed {
+		return false
+	}
 
-	for _, cs := range o.clusterSinks {
-		cs.queueMessage(msg)
+	msg, targets := o.route(record, ts, tag)
+	if o.backpressure && !accepting(targets, msg) {
+		return false
 	}
 
-	namespaceSinks, ok := o.sinks[namespace]
-	if !ok {
-		// TODO: track ignored messages
-		return
+	queued := true
+	for _, s := range targets {
+		if !s.queueMessage(msg) {
+			queued = false
+		}
+	}
+	return queued
+}
+
+// Record is a record of a chunk passed to WriteChunk.
+type Record struct {
+	Record map[interface{}]interface{}
+	Time   time.Time
+}
+
+// WriteChunk writes the records of a chunk with the same tag like Write
+// and reports whether the required sinks queued all records routed to
+// them. With backpressure no record is queued unless every required sink
+// can queue all records of the chunk routed to it, so that a refused chunk
+// can be written again without duplicating records. A chunk with more
+// records for a sink than fit into its queue is accepted once the queue is
+// empty.
+func (o *Out) WriteChunk(records []Record, tag string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.closed {
+		return false
+	}
+
+	routed := make(map[*Sink][]io.WriterTo)
+	var order []*Sink
+	for _, r := range records {
+		msg, targets := o.route(r.Record, r.Time, tag)
+		for _, s := range targets {
+			if _, ok := routed[s]; !ok {
+				order = append(order, s)
+			}
+			routed[s] = append(routed[s], msg)
+		}
 	}
 
-	for _, s := range namespaceSinks {
-		s.queueMessage(msg)
+	if o.backpressure {
+		o.chunkMu.Lock()
+		defer o.chunkMu.Unlock()
+		for _, s := range order {
+			if s.Required && !s.acceptingAll(routed[s]) {
+				return false
+			}
+		}
+	}
+
+	queued := true
+	for _, s := range order {
+		for _, msg := range routed[s] {
+			if o.backpressure && s.Required {
+				if !s.queueAccepted(msg) {
+					queued = false
+				}
+				continue
+			}
+			if !s.queueMessage(msg) && s.Required {
+				queued = false
+			}
+		}
+	}
+	return queued
+}
+
+// route converts a record into a syslog message and returns the sinks it
+// is written to.
+func (o *Out) route(
+	record map[interface{}]interface{},
+	ts time.Time,
+	tag string,
+) (io.WriterTo, []*Sink) {
+	msg, namespace := convert(record, ts, tag, o.sanitizeHost)
+	targets := o.clusterSinks
+	// TODO: track ignored messages of namespaces without sinks
+	if namespaceSinks, ok := o.sinks[namespace]; ok {
+		targets = append(targets[:len(targets):len(targets)], namespaceSinks...)
 	}
+	return msg, targets
 }
 
 func (o *Out) SinkState() []SinkState {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
 	var stats []SinkState
 	for _, sinks := range o.sinks {
 		for _, s := range sinks {
-			stats = append(stats, SinkState{
-				Name:               s.Name,
-				Namespace:          s.Namespace,
-				LastSuccessfulSend: time.Unix(0, atomic.LoadInt64(&s.lastSendSuccessNanos)),
-				Error:              s.LoadSinkError(),
-			})
+			stats = append(stats, s.state())
 		}
 	}
 
 	for _, s := range o.clusterSinks {
-		stats = append(stats, SinkState{
-			Name:               s.Name,
-			LastSuccessfulSend: time.Unix(0, atomic.LoadInt64(&s.lastSendSuccessNanos)),
-			Error:              s.LoadSinkError(),
-		})
+		state := s.state()
+		state.Namespace = ""
+		stats = append(stats, state)
 	}
 
 	return stats
 }
 
+func (s *Sink) state() SinkState {
+	state := SinkState{
+		Name:                s.Name,
+		Namespace:           s.Namespace,
+		LastSuccessfulSend:  time.Unix(0, atomic.LoadInt64(&s.lastSendSuccessNanos)),
+		Error:               s.LoadSinkError(),
+		ClientCertExpiry:    loadTime(&s.clientCertExpiryNanos),
+		RootCAExpiry:        loadTime(&s.rootCAExpiryNanos),
+		ActiveAddr:          s.activeAddr(),
+		NextRetry:           loadTime(&s.nextRetryNanos),
+		ConsecutiveFailures: atomic.LoadInt64(&s.consecutiveFailures),
+		MessagesTruncated:   s.MessagesTruncated(),
+	}
+	if s.diskQueue != nil {
+		state.DiskQueueMessages, state.DiskQueueBytes = s.diskQueue.stats()
+	}
+	if s.connections != nil {
+		state.ActiveAddr = ""
+		state.Connections = s.connections()
+	}
+	if len(s.workers) != 0 {
+		s.aggregateWorkers(&state)
+	}
+	return state
+}
+
+// loadTime returns the time stored in nanoseconds or nil if it is not set.
+func loadTime(nanos *int64) *time.Time {
+	n := atomic.LoadInt64(nanos)
+	if n == 0 {
+		return nil
+	}
+	t := time.Unix(0, n)
+	return &t
+}
+
 func (s *Sink) LoadSinkError() *SinkError {
 	if sinkError, ok := s.writeErr.Load().(SinkError); ok && sinkError.Msg != "" {
 		return &sinkError
@@ -203,111 +541,453 @@ func (s *Sink) LoadSinkError() *SinkError {
 	return nil
 }
 
+// startSink configures the sink's transport and starts delivering its
+// messages.
+func (o *Out) startSink(s *Sink) {
+	o.configure(s)
+
+	// Invalid sinks are started without workers so that they report the
+	// validation error.
+	if s.Workers > 1 && s.Validate() == nil {
+		s.startWorkers(o)
+		return
+	}
+
+	setupTransport(s, o)
+	if s.DiskQueue != nil && s.Validate() == nil {
+		q, err := openDiskQueue(s.DiskQueue)
+		if err != nil {
+			log.Printf("Sink to address %s, at namespace [%s] failed to open its disk queue: %s\n", s.Addr, s.Namespace, err)
+			s.storeError(fmt.Errorf("disk queue: %s", err))
+		}
+		s.diskQueue = q
+	}
+	s.start(s.bufferSize)
+}
+
+// configure applies the options of out to the sink. Options the sink sets
+// itself take precedence.
+func (o *Out) configure(s *Sink) {
+	s.writeTimeout = o.writeTimeout
+	if s.WriteTimeout > 0 {
+		s.writeTimeout = s.WriteTimeout
+	}
+	s.bufferSize = o.bufferSize
+	if s.BufferSize > 0 {
+		s.bufferSize = s.BufferSize
+	}
+	s.tlsReloadInterval = o.tlsReloadInterval
+	s.dialTimeout = o.dialTimeout
+	if s.DialTimeout > 0 {
+		s.dialTimeout = s.DialTimeout
+	}
+	s.failbackInterval = o.failbackInterval
+	s.resolver = o.resolver
+	s.resolveInterval = o.resolveInterval
+	s.minReconnectBackoff = o.minReconnectBackoff
+	s.maxReconnectBackoff = o.maxReconnectBackoff
+	s.retryAttempts = o.retryAttempts
+	s.retryMaxAge = o.retryMaxAge
+}
+
 func (s *Sink) start(bufferSize int) {
 	s.messages = make(chan io.WriterTo, bufferSize)
+	s.run()
+}
+
+// run writes the sink's queued messages until its queue is closed or the
+// sink is aborted. The sink's connection is closed when it stops.
+func (s *Sink) run() {
+	s.done = make(chan struct{})
+	s.abort = make(chan struct{})
 	go func() {
-		for m := range s.messages {
-			s.write(m)
+		defer close(s.done)
+		defer s.closeConnection()
+
+		var reload <-chan time.Time
+		if s.TLS != nil && s.tlsReloadInterval > 0 {
+			ticker := time.NewTicker(s.tlsReloadInterval)
+			defer ticker.Stop()
+			reload = ticker.C
+		}
+		var failback <-chan time.Time
+		if (len(s.FailoverAddrs) != 0 || s.Discovery != "") && s.LoadBalancing == "" && s.failbackInterval > 0 {
+			ticker := time.NewTicker(s.failbackInterval)
+			defer ticker.Stop()
+			failback = ticker.C
+		}
+		var resolve <-chan time.Time
+		if s.Discovery != "" && s.resolveInterval > 0 {
+			ticker := time.NewTicker(s.resolveInterval)
+			defer ticker.Stop()
+			resolve = ticker.C
+		}
+
+		var spooled <-chan struct{}
+		if s.diskQueue != nil {
+			spooled = s.diskQueue.notify
+		}
+
+		for {
+			select {
+			case <-s.abort:
+				return
+			default:
+			}
+
+			messages, replay := s.messages, spooled
+			var (
+				timer *time.Timer
+				retry <-chan time.Time
+			)
+			if s.held != nil {
+				// Wait for the held message to be sent before taking
+				// any other message.
+				messages, replay = nil, nil
+				timer = time.NewTimer(time.Until(time.Unix(0, atomic.LoadInt64(&s.nextRetryNanos))))
+				retry = timer.C
+			}
+
+			select {
+			case m, ok := <-messages:
+				if !ok {
+					return
+				}
+				s.write(m)
+			case <-retry:
+				m := s.held
+				s.held = nil
+				s.write(m)
+				if s.heldOnDisk && s.held == nil {
+					s.heldOnDisk = false
+					s.diskQueue.commit()
+				}
+			case <-replay:
+				s.replay()
+			case <-reload:
+				s.reloadTLS()
+			case <-failback:
+				s.probePrimary()
+			case <-resolve:
+				s.reresolve()
+			case <-s.abort:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
 		}
 	}()
 }
 
-func (s *Sink) queueMessage(msg io.WriterTo) {
+// queueMessage adds msg to the sink's queue and reports whether it was
+// queued.
+func (s *Sink) queueMessage(msg io.WriterTo) bool {
+	if s.diskQueue != nil {
+		return s.spool(msg)
+	}
+
 	select {
-	case s.messages <- msg:
+	case s.queue(msg) <- msg:
+		return true
 	default:
-		md := atomic.AddInt64(&s.messagesDropped, 1)
-		if md%1000 == 0 && md != 0 {
-			log.Printf("Sink to address %s, at namespace [%s] dropped %d messages\n", s.Addr, s.Namespace, md)
-		}
+		s.dropQueued()
+		return false
+	}
+}
+
+// dropQueued drops a message that does not fit into the sink's queue.
+func (s *Sink) dropQueued() {
+	md := atomic.AddInt64(&s.messagesDropped, 1)
+	if md%1000 == 0 && md != 0 {
+		log.Printf("Sink to address %s, at namespace [%s] dropped %d messages\n", s.Addr, s.Namespace, md)
 	}
 }
 
 // write writes a rfc5424 syslog message to the connection of the specified
-// sink. It recreates the connection if one isn't established yet.
+// sink. It recreates the connection if one isn't established yet. A message
+// that fails to send is sent again on a new connection according to the
+// sink's retry policy before the next message is written.
 func (s *Sink) write(w io.WriterTo) {
 	defer atomic.StoreInt64(&s.lastSendAttemptNanos, time.Now().UnixNano())
 
-	err := s.maintainConnection()
-	if err != nil {
-		atomic.AddInt64(&s.messagesDropped, 1)
-		s.writeErr.Store(SinkError{
-			Msg:       err.Error(),
-			Timestamp: time.Now(),
-		})
+	if s.inBackoff() {
+		s.drop(w)
 		return
 	}
-	_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
-	_, err = w.WriteTo(s.conn)
-	if err != nil {
-		s.conn.Close()
-		s.conn = nil
-		atomic.AddInt64(&s.messagesDropped, 1)
-		s.writeErr.Store(SinkError{
-			Msg:       err.Error(),
-			Timestamp: time.Now(),
-		})
+
+	start := time.Now()
+	for retries := 0; ; retries++ {
+		err := s.maintainConnection()
+		if err != nil {
+			atomic.StoreInt32(&s.connected, 0)
+			s.backoff()
+			s.storeError(err)
+			if s.diskQueue != nil {
+				s.held = w
+				return
+			}
+			// Messages are only retried once they failed to send.
+			if retries == 0 || !s.retry(retries, start) {
+				s.drop(w)
+				return
+			}
+			continue
+		}
+		s.resetBackoff()
+		if s.conn != nil {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		err = s.send(w)
+		if err != nil {
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			atomic.StoreInt32(&s.connected, 0)
+			s.storeError(err)
+			if _, ok := err.(retainedError); ok {
+				return
+			}
+			if !s.retry(retries, start) {
+				if s.diskQueue != nil {
+					s.backoff()
+					s.held = w
+					return
+				}
+				s.drop(w)
+				return
+			}
+			continue
+		}
+		atomic.StoreInt32(&s.connected, 1)
+		s.writeErr.Store(SinkError{})
+		atomic.StoreInt64(&s.lastSendSuccessNanos, time.Now().UnixNano())
+		atomic.AddInt64(&s.messagesSent, 1)
 		return
 	}
-	s.writeErr.Store(SinkError{})
-	atomic.StoreInt64(&s.lastSendSuccessNanos, time.Now().UnixNano())
 }
 
-func (s *Sink) MessagesDropped() int64 {
-	return atomic.LoadInt64(&s.messagesDropped)
+// drop counts the message as dropped together with the messages batched
+// behind it.
+func (s *Sink) drop(w io.WriterTo) {
+	n := int64(1)
+	if s.batchFor != nil && s.batchFor == w {
+		n += int64(len(s.batched))
+		s.batchFor, s.batched = nil, nil
+	}
+	atomic.AddInt64(&s.messagesDropped, n)
 }
 
-func tlsMaintainConn(s *Sink, out *Out) func() error {
-	return func() error {
-		if s.conn == nil {
-			var (
-				conn  net.Conn // conn needs to be of type net.Conn, not *tls.Conn
-				roots *x509.CertPool
-				pem   []byte
-				err   error
-			)
+// storeError records err as the sink's most recent error.
+func (s *Sink) storeError(err error) {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	_, proxy := err.(*proxyError)
+	s.writeErr.Store(SinkError{
+		Msg:       err.Error(),
+		Timestamp: time.Now(),
+		Proxy:     proxy,
+	})
+}
 
-			if !s.TLS.InsecureSkipVerify && s.TLS.RootCA != "" {
-				roots = x509.NewCertPool()
+func (s *Sink) MessagesDropped() int64 {
+	md := atomic.LoadInt64(&s.messagesDropped)
+	for _, w := range s.workers {
+		md += w.MessagesDropped()
+	}
+	return md
+}
 
-				pem, err = ioutil.ReadFile(s.TLS.RootCA)
-				if err != nil {
-					return err
-				}
+// MessagesTruncated returns the number of messages that were larger than
+// the maximum datagram size and were sent truncated.
+func (s *Sink) MessagesTruncated() int64 {
+	mt := atomic.LoadInt64(&s.messagesTruncated)
+	for _, w := range s.workers {
+		mt += w.MessagesTruncated()
+	}
+	return mt
+}
 
-				if ok := roots.AppendCertsFromPEM(pem); !ok {
-					return fmt.Errorf("append certificate failed")
-				}
+// Validate returns an error if the sink's transport or format is not
+// supported, its TLS configuration is incomplete or the transport can not be
+// combined with the sink's TLS and framing configuration.
+func (s *Sink) Validate() error {
+	err := validateTransport(s.Transport, s.TLS)
+	if err != nil {
+		return err
+	}
+	if s.Transport == TransportHTTPS {
+		for _, addr := range s.addrs() {
+			err = validateDrainURL(addr, s.TLS)
+			if err != nil {
+				return err
 			}
+		}
+	}
+	err = validateTLS(s.TLS)
+	if err != nil {
+		return err
+	}
+	err = validateFraming(s.Framing, s.Transport)
+	if err != nil {
+		return err
+	}
+	err = validateProxy(s.Proxy, s.Transport)
+	if err != nil {
+		return err
+	}
+	err = validateDiscovery(s.Discovery, s.Transport, s.Addr)
+	if err != nil {
+		return err
+	}
+	err = validateLoadBalancing(s.LoadBalancing, s.Transport)
+	if err != nil {
+		return err
+	}
+	err = validateWorkers(s.Workers, s.ShardByPod, s.LoadBalancing)
+	if err != nil {
+		return err
+	}
+	err = validateDiskQueue(s.DiskQueue, s.Workers)
+	if err != nil {
+		return err
+	}
+	if s.DialTimeout < 0 || s.WriteTimeout < 0 || s.BufferSize < 0 {
+		return errors.New("dial timeout, write timeout and buffer size must not be negative")
+	}
+	return validateFormat(s.Format)
+}
 
-			conn, err = tls.DialWithDialer(
-				&net.Dialer{
-					Timeout: out.dialTimeout,
-				},
-				"tcp",
-				s.Addr,
-				&tls.Config{
-					InsecureSkipVerify: s.TLS.InsecureSkipVerify,
-					RootCAs:            roots,
-				},
-			)
+func validateTransport(transport string, t *TLS) error {
+	switch transport {
+	case "", TransportTCP, TransportRELP, TransportHTTPS:
+		return nil
+	case TransportUDP, TransportUnix, TransportUnixgram:
+		if t != nil {
+			return fmt.Errorf("transport %s does not support TLS", transport)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported transport %q", transport)
+}
 
-			if err == nil {
-				s.conn = conn
+// setupTransport configures how the sink connects to its destination and
+// how messages are written onto that connection.
+func setupTransport(s *Sink, out *Out) {
+	err := s.Validate()
+	if err != nil {
+		s.maintainConnection = func() error {
+			return err
+		}
+		return
+	}
+
+	if s.LoadBalancing != "" {
+		s.maxDatagramSize = out.maxDatagramSize
+		b := newBalancer(s, out)
+		s.maintainConnection = b.maintainMembers
+		s.send = b.send
+		s.connections = b.state
+		s.disconnect = b.close
+		return
+	}
+
+	switch s.Transport {
+	case TransportUDP, TransportUnixgram:
+		s.maxDatagramSize = out.maxDatagramSize
+		s.maintainConnection = dialMaintainConn(s, s.Transport)
+		s.send = datagramSend(s)
+	case TransportUnix:
+		s.maintainConnection = dialMaintainConn(s, s.Transport)
+		s.send = streamSend(s, out)
+	case TransportRELP:
+		c := newRELPClient(s, out)
+		s.maintainConnection = c.maintainConn
+		s.send = c.send
+		s.disconnect = c.close
+	case TransportHTTPS:
+		c := newHTTPClient(s, out)
+		s.maintainConnection = c.maintainClient
+		s.send = c.send
+		s.disconnect = c.close
+	default:
+		if s.TLS != nil {
+			s.maintainConnection = tlsMaintainConn(s)
+		} else {
+			s.maintainConnection = dialMaintainConn(s, "tcp")
+		}
+		s.send = streamSend(s, out)
+	}
+}
+
+// streamSend writes framed messages onto the sink's stream connection.
+// With write batching the messages queued behind the message are written
+// together with it in a single write. A batch that fails to write is kept
+// and written again when the message is sent again.
+func streamSend(s *Sink, out *Out) func(io.WriterTo) error {
+	if out.batchBytes <= 0 {
+		return func(w io.WriterTo) error {
+			b, err := s.marshal(w)
+			if err != nil {
+				return err
 			}
+			_, err = s.conn.Write(s.frame(b))
 			return err
 		}
+	}
+
+	var buf bytes.Buffer
+	return func(w io.WriterTo) error {
+		if s.batchFor == nil || s.batchFor != w {
+			b, err := s.marshal(w)
+			if err != nil {
+				return err
+			}
+			buf.Reset()
+			buf.Write(s.frame(b))
+			s.batched = s.batch(&buf, out.batchBytes, out.batchLinger)
+			s.batchFor = w
+		}
+
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		_, err := s.conn.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		// The message passed to send is counted by the caller.
+		atomic.AddInt64(&s.messagesSent, int64(len(s.batched)))
+		s.batchFor, s.batched = nil, nil
 		return nil
 	}
 }
 
-func tcpMaintainConn(s *Sink, out *Out) func() error {
+// marshal returns the syslog message in the sink's format without any
+// framing.
+func (s *Sink) marshal(w io.WriterTo) ([]byte, error) {
+	if s.Format == FormatRFC3164 {
+		m, ok := w.(*rfc5424.Message)
+		if !ok {
+			return nil, fmt.Errorf("unable to format message of type %T", w)
+		}
+		return marshalRFC3164(m), nil
+	}
+
+	m, ok := w.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("unable to marshal message of type %T", w)
+	}
+	return m.MarshalBinary()
+}
+
+// dialMaintainConn establishes a plain connection of the given network
+// (tcp, udp, unix or unixgram) to the sink's address.
+func dialMaintainConn(s *Sink, network string) func() error {
 	return func() error {
 		if s.conn == nil {
-			dialer := net.Dialer{
-				Timeout: out.dialTimeout,
-			}
-			conn, err := dialer.Dial("tcp", s.Addr)
+			conn, err := s.connect(func(addr string) (net.Conn, error) {
+				return s.dial(network, addr, s.dialTimeout)
+			})
 			if err == nil {
 				s.conn = conn
 			}
@@ -2,6 +2,7 @@ package syslog_test
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"code.cloudfoundry.org/rfc5424"
@@ -293,6 +294,7 @@ var _ = Describe("Out", func() {
 						"container_name": []byte("container-name"),
 					},
 				}
+				waitForRetry(out)
 				out.Write(record2, time.Unix(0, 0).UTC(), "k8s.event._ns1_")
 				spySink.accept().Close()
 
@@ -793,6 +795,67 @@ var _ = Describe("Out", func() {
 		Eventually(slowSink.MessagesDropped, 2*time.Second).Should(BeNumerically("==", 100))
 	})
 
+	It("uses the buffer size of the sink over the one of out", func() {
+		// The listener never completes a TLS handshake so the sink is
+		// blocked until its dial timeout.
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+
+		blockedSink := &syslog.Sink{
+			Addr:        lis.Addr().String(),
+			Namespace:   "ns1",
+			TLS:         &syslog.TLS{InsecureSkipVerify: true},
+			BufferSize:  1,
+			DialTimeout: 2 * time.Second,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{blockedSink},
+			[]*syslog.Sink{},
+			syslog.WithBufferSize(10000),
+		)
+
+		for i := 0; i < 100; i++ {
+			r1 := map[interface{}]interface{}{
+				"log": []byte("some-log-for-ns1"),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("ns1"),
+				},
+			}
+			out.Write(r1, time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Expect(blockedSink.MessagesDropped()).To(BeNumerically(">=", 98))
+	})
+
+	It("uses the write timeout of the sink over the one of out", func() {
+		spySlowSink := newSpySink()
+		defer spySlowSink.stop()
+
+		slowSink := &syslog.Sink{
+			Addr:         spySlowSink.url(),
+			Namespace:    "ns1",
+			WriteTimeout: time.Nanosecond,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{slowSink},
+			[]*syslog.Sink{},
+			syslog.WithWriteTimeout(time.Minute),
+		)
+
+		for i := 0; i < 100; i++ {
+			r1 := map[interface{}]interface{}{
+				"log": []byte("some-log-for-ns1"),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("ns1"),
+				},
+			}
+			out.Write(r1, time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(slowSink.MessagesDropped, 2*time.Second).Should(BeNumerically("==", 100))
+	})
+
 	It("doesn't slow down a sink even if another sink isn't able to connect", func() {
 		spySink := newSpySink()
 		defer spySink.stop()
@@ -1149,6 +1212,7 @@ var _ = Describe("Out", func() {
 					"namespace_name": []byte("ns-123"),
 				},
 			}
+			waitForRetry(out)
 			out.Write(r2, time.Unix(0, 0).UTC(), "pod.log")
 			spySink.expectReceivedIncludes(
 				`<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns-123// - - [kubernetes@47450 namespace_name="ns-123" object_name="" container_name=""] some-message-2` + "\n",
@@ -1220,7 +1284,8 @@ var _ = Describe("Out", func() {
 				out.Write(r2, time.Unix(0, 0).UTC(), "pod.log")
 				return s.MessagesDropped()
 			}
-			Eventually(f).Should(BeNumerically(">=", 1))
+			// The failed message is retried before it is dropped.
+			Eventually(f, 5*time.Second).Should(BeNumerically(">=", 1))
 
 			spySink = newSpySink(spySink.url())
 			r3 := map[interface{}]interface{}{
@@ -1229,6 +1294,7 @@ var _ = Describe("Out", func() {
 					"namespace_name": []byte("some-namespace"),
 				},
 			}
+			waitForRetry(out)
 			out.Write(r3, time.Unix(0, 0).UTC(), "pod.log")
 
 			spySink.expectReceivedIncludes(
@@ -1290,6 +1356,7 @@ var _ = Describe("Out", func() {
 						"namespace_name": []byte("some-namespace"),
 					},
 				}
+				waitForRetry(out)
 				out.Write(r2, time.Unix(0, 0).UTC(), "pod.log")
 			}()
 
@@ -1351,7 +1418,14 @@ var _ = Describe("Out", func() {
 					InsecureSkipVerify: true,
 				},
 			}
-			out := syslog.NewOut([]*syslog.Sink{&s}, nil)
+			// The handshake with the new spy sink blocks until the dial
+			// timeout, disable retries so that messages are dropped after
+			// the first attempt.
+			out := syslog.NewOut(
+				[]*syslog.Sink{&s},
+				nil,
+				syslog.WithRetry(0, 0),
+			)
 			// TLS will block on waiting for handshake so the write needs
 			// to occur in a separate go routine
 			go func() {
@@ -1392,6 +1466,7 @@ var _ = Describe("Out", func() {
 						"namespace_name": []byte("some-namespace"),
 					},
 				}
+				waitForRetry(out)
 				out.Write(r3, time.Unix(0, 0).UTC(), "pod.log")
 			}()
 
@@ -0,0 +1,328 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyEnvironment makes a sink use the proxy configured by the
+// HTTPS_PROXY (or ALL_PROXY) and NO_PROXY environment variables.
+const ProxyEnvironment = "env"
+
+// proxyError is returned when a connection through a proxy could not be
+// established. It is reported separately from other sink errors.
+type proxyError struct {
+	addr string
+	err  error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %s", e.addr, e.err)
+}
+
+func validateProxy(proxy, transport string) error {
+	if proxy == "" {
+		return nil
+	}
+	switch transport {
+	case "", TransportTCP, TransportRELP, TransportHTTPS:
+	default:
+		return fmt.Errorf("transport %s does not support proxies", transport)
+	}
+	if proxy == ProxyEnvironment {
+		return nil
+	}
+	_, err := parseProxyURL(proxy)
+	return err
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	// Proxies without a scheme are HTTP proxies by convention.
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("proxy url %q must be an http or socks5 url", proxy)
+	}
+	return u, nil
+}
+
+// proxyFor returns the proxy to use for connecting to addr or nil if the
+// connection should be made directly.
+func (s *Sink) proxyFor(addr string) (*url.URL, error) {
+	if s.Proxy == "" {
+		return nil, nil
+	}
+	if s.Proxy != ProxyEnvironment {
+		return parseProxyURL(s.Proxy)
+	}
+
+	proxy := getenv("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy")
+	if proxy == "" || bypassProxy(addr, getenv("NO_PROXY", "no_proxy")) {
+		return nil, nil
+	}
+	return parseProxyURL(proxy)
+}
+
+func getenv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// bypassProxy reports whether addr matches the comma separated NO_PROXY
+// list. Entries are either "*", an IP address, a CIDR range or a domain
+// which also matches its subdomains, optionally followed by a port.
+func bypassProxy(addr, noProxy string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// dial connects to addr, through the sink's proxy if one is configured.
+func (s *Sink) dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+
+	proxy, err := s.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil || network != "tcp" {
+		return dialer.Dial(network, addr)
+	}
+
+	conn, err := dialer.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, &proxyError{addr: proxy.Host, err: err}
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	switch proxy.Scheme {
+	case "socks5":
+		err = socks5Connect(conn, proxy, addr)
+	default:
+		conn, err = httpConnect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &proxyError{addr: proxy.Host, err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into a
+// buffer.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// httpConnect establishes a tunnel to addr with the HTTP CONNECT method.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return conn, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT responded with %s", resp.Status)
+	}
+
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// socks5Connect establishes a connection to addr as defined in RFC 1928
+// with username/password authentication as defined in RFC 1929.
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	method := byte(0x00)
+	if proxy.User != nil {
+		method = 0x02
+	}
+	_, err = conn.Write([]byte{0x05, 0x01, method})
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[0] != 0x05 || resp[1] != method {
+		return errors.New("socks5 authentication method not accepted")
+	}
+
+	if method == 0x02 {
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("socks5 credentials too long")
+		}
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		_, err = conn.Write(auth)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadFull(conn, resp)
+		if err != nil {
+			return err
+		}
+		if resp[1] != 0x00 {
+			return errors.New("socks5 authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5 host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("socks5 connect failed with code %d", reply[1])
+	}
+
+	var addrLen int
+	switch reply[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		_, err = io.ReadFull(conn, l)
+		if err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return fmt.Errorf("socks5 reply has unknown address type %d", reply[3])
+	}
+
+	// Discard the bound address and port.
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
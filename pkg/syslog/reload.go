@@ -0,0 +1,234 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// ReloadState is the outcome of the latest reload of the sinks of an Out.
+type ReloadState struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Error is set if the reload failed, in which case the sinks were
+	// left as they were.
+	Error     string `json:"error,omitempty"`
+	Added     int    `json:"added"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+}
+
+// Reload replaces the sinks of o with sinks and clusterSinks. Sinks that are
+// configured like one of the running sinks are not started; the running
+// sink keeps delivering its queued messages instead. New sinks are started
+// and the sinks that are no longer configured are closed once they sent
+// their queued messages or ctx is done. A removed sink whose disk queue
+// directory is used by a new sink is closed before the new sink starts. If
+// any of the sinks is invalid the running sinks are left as they are.
+func (o *Out) Reload(ctx context.Context, sinks, clusterSinks []*Sink) error {
+	for _, s := range append(sinks[:len(sinks):len(sinks)], clusterSinks...) {
+		err := s.Validate()
+		if err != nil {
+			err = fmt.Errorf("sink %q: %s", s.Name, err)
+			o.reloadFailed(err)
+			return err
+		}
+	}
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return errors.New("out is closed")
+	}
+
+	var running []*Sink
+	var configs []SinkConfig
+	for _, ss := range o.sinks {
+		for _, s := range ss {
+			running = append(running, s)
+			configs = append(configs, s.config(false))
+		}
+	}
+	for _, s := range o.clusterSinks {
+		running = append(running, s)
+		configs = append(configs, s.config(true))
+	}
+	kept := make([]bool, len(running))
+
+	// match returns the running sink configured like s or s, which is
+	// added.
+	state := ReloadState{Timestamp: time.Now()}
+	var added []*Sink
+	match := func(s *Sink, cluster bool) *Sink {
+		c := s.config(cluster)
+		for i, r := range running {
+			if !kept[i] && reflect.DeepEqual(configs[i], c) {
+				kept[i] = true
+				state.Unchanged++
+				return r
+			}
+		}
+		added = append(added, s)
+		state.Added++
+		return s
+	}
+
+	m := make(map[string][]*Sink)
+	for _, s := range sinks {
+		s = match(s, false)
+		m[s.Namespace] = append(m[s.Namespace], s)
+	}
+	var cs []*Sink
+	for _, s := range clusterSinks {
+		cs = append(cs, match(s, true))
+	}
+	var removed []*Sink
+	for i, r := range running {
+		if !kept[i] {
+			removed = append(removed, r)
+		}
+	}
+	state.Removed = len(removed)
+
+	// Removed sinks release their disk queue before an added sink opens
+	// the same directory. Write waits meanwhile so that no message is
+	// routed to the removed sinks or the added sinks that did not start.
+	removed, releaseErr := releaseDiskQueues(ctx, removed, added)
+	for _, s := range added {
+		o.startSink(s)
+	}
+
+	o.sinks = m
+	o.clusterSinks = cs
+	o.reloadState = &state
+	o.mu.Unlock()
+
+	log.Printf("Reloaded sinks: %d added, %d removed, %d unchanged\n", state.Added, state.Removed, state.Unchanged)
+	err := drain(ctx, removed)
+	if releaseErr != nil {
+		return releaseErr
+	}
+	return err
+}
+
+// releaseDiskQueues drains the removed sinks whose disk queue directory is
+// used by one of the added sinks and returns the other removed sinks.
+func releaseDiskQueues(ctx context.Context, removed, added []*Sink) ([]*Sink, error) {
+	dirs := make(map[string]bool)
+	for _, s := range added {
+		if s.DiskQueue != nil {
+			dirs[filepath.Clean(s.DiskQueue.Dir)] = true
+		}
+	}
+	if len(dirs) == 0 {
+		return removed, nil
+	}
+
+	var released, rest []*Sink
+	for _, s := range removed {
+		if s.DiskQueue != nil && dirs[filepath.Clean(s.DiskQueue.Dir)] {
+			released = append(released, s)
+			continue
+		}
+		rest = append(rest, s)
+	}
+	return rest, drain(ctx, released)
+}
+
+// ReloadState returns the outcome of the latest reload or nil if the sinks
+// were never reloaded.
+func (o *Out) ReloadState() *ReloadState {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.reloadState == nil {
+		return nil
+	}
+	state := *o.reloadState
+	return &state
+}
+
+func (o *Out) reloadFailed(err error) {
+	log.Printf("Failed to reload sinks: %s\n", err)
+	o.mu.Lock()
+	o.reloadState = &ReloadState{
+		Timestamp: time.Now(),
+		Error:     err.Error(),
+	}
+	o.mu.Unlock()
+}
+
+// SinksFileWatcher reloads the sinks of an Out when its sinks file changes.
+type SinksFileWatcher struct {
+	Out  *Out
+	Path string
+	// Sinks and ClusterSinks are kept in addition to the sinks declared
+	// in the file.
+	Sinks        []*Sink
+	ClusterSinks []*Sink
+	// GracePeriod is how long removed sinks may take to send their
+	// queued messages.
+	GracePeriod time.Duration
+
+	content []byte
+}
+
+// Load reads the sinks declared in the file.
+func (w *SinksFileWatcher) Load() (sinks, clusterSinks []*Sink, err error) {
+	content, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sinks, clusterSinks, err = ParseSinks(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.content = content
+	return sinks, clusterSinks, nil
+}
+
+// Check reloads the sinks if the file changed since it was last loaded.
+// The sinks are left as they are if the file can not be loaded.
+func (w *SinksFileWatcher) Check() error {
+	content, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		w.Out.reloadFailed(err)
+		return err
+	}
+	if w.content != nil && bytes.Equal(content, w.content) {
+		return nil
+	}
+	w.content = content
+
+	sinks, clusterSinks, err := ParseSinks(content)
+	if err != nil {
+		w.Out.reloadFailed(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.GracePeriod)
+	defer cancel()
+	return w.Out.Reload(
+		ctx,
+		append(sinks, w.Sinks...),
+		append(clusterSinks, w.ClusterSinks...),
+	)
+}
+
+// Run checks the file every interval until stop is closed.
+func (w *SinksFileWatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = w.Check()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package syslog_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Reload", func() {
+	var record func(ns, msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(ns, msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte(ns),
+				},
+			}
+		}
+	})
+
+	It("starts new sinks, closes removed ones and keeps unchanged ones", func() {
+		kept := newCollector()
+		defer kept.stop()
+		removed := newCollector()
+		defer removed.stop()
+		added := newCollector()
+		defer added.stop()
+
+		s := &syslog.Sink{Name: "kept", Addr: kept.url(), Namespace: "ns-a"}
+		rs := &syslog.Sink{Name: "removed", Addr: removed.url(), Namespace: "ns-b"}
+		out := syslog.NewOut([]*syslog.Sink{s, rs}, nil)
+		out.Write(record("ns-a", "before"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("ns-b", "before"), time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(s.MessagesSent).Should(Equal(int64(1)))
+
+		err := out.Reload(
+			context.Background(),
+			[]*syslog.Sink{{Name: "kept", Addr: kept.url(), Namespace: "ns-a"}},
+			[]*syslog.Sink{{Name: "added", Addr: added.url()}},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(removed.received).Should(Equal([]string{"before\n"}))
+
+		out.Write(record("ns-a", "after"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("ns-b", "after"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(kept.received).Should(Equal([]string{"before\n", "after\n"}))
+		Eventually(added.received).Should(Equal([]string{"after\n", "after\n"}))
+		Consistently(removed.received, 100*time.Millisecond).Should(HaveLen(1))
+		Expect(s.MessagesSent()).To(Equal(int64(2)))
+		Expect(kept.receivedByConn()).To(HaveLen(1))
+
+		state := out.ReloadState()
+		Expect(state).ToNot(BeNil())
+		Expect(state.Error).To(BeEmpty())
+		Expect(state.Added).To(Equal(1))
+		Expect(state.Removed).To(Equal(1))
+		Expect(state.Unchanged).To(Equal(1))
+		Expect(out.SinkState()).To(HaveLen(2))
+	})
+
+	It("sends the queued messages of removed sinks", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{Name: "removed", Addr: c.url(), Namespace: "ns-a"}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		for i := 0; i < 100; i++ {
+			out.Write(record("ns-a", "log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+		}
+		Expect(out.Reload(context.Background(), nil, nil)).To(Succeed())
+
+		Expect(s.MessagesSent()).To(Equal(int64(100)))
+		Eventually(c.received).Should(HaveLen(100))
+	})
+
+	It("keeps the running sinks if any sink is invalid", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{Name: "kept", Addr: c.url(), Namespace: "ns-a"}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		err := out.Reload(
+			context.Background(),
+			[]*syslog.Sink{{Name: "invalid", Addr: c.url(), Namespace: "ns-a", Transport: "carrier-pigeon"}},
+			nil,
+		)
+		Expect(err).To(MatchError(ContainSubstring(`sink "invalid": unsupported transport`)))
+
+		out.Write(record("ns-a", "log"), time.Unix(0, 0).UTC(), "pod.log")
+		Eventually(c.received).Should(Equal([]string{"log\n"}))
+		Expect(out.ReloadState().Error).To(ContainSubstring("unsupported transport"))
+	})
+
+	Describe("SinksFileWatcher", func() {
+		var (
+			dir  string
+			path string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "sinks-file")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "sinks.yml")
+		})
+
+		AfterEach(func() {
+			_ = os.RemoveAll(dir)
+		})
+
+		writeSinks := func(doc string) {
+			ExpectWithOffset(1, ioutil.WriteFile(path, []byte(doc), 0600)).To(Succeed())
+		}
+
+		It("reloads the sinks when the file changes", func() {
+			a := newCollector()
+			defer a.stop()
+			b := newCollector()
+			defer b.stop()
+			static := newCollector()
+			defer static.stop()
+
+			writeSinks("sinks: [{name: a, namespace: ns-a, addr: " + a.url() + "}]")
+			staticSink := &syslog.Sink{Name: "static", Addr: static.url()}
+			w := &syslog.SinksFileWatcher{
+				Path:         path,
+				ClusterSinks: []*syslog.Sink{staticSink},
+				GracePeriod:  time.Second,
+			}
+			sinks, clusterSinks, err := w.Load()
+			Expect(err).ToNot(HaveOccurred())
+			w.Out = syslog.NewOut(sinks, append(clusterSinks, staticSink))
+
+			Expect(w.Check()).To(Succeed())
+			Expect(w.Out.ReloadState()).To(BeNil())
+
+			writeSinks("sinks: [{name: b, namespace: ns-a, addr: " + b.url() + "}]")
+			Expect(w.Check()).To(Succeed())
+
+			w.Out.Write(record("ns-a", "log"), time.Unix(0, 0).UTC(), "pod.log")
+			Eventually(b.received).Should(Equal([]string{"log\n"}))
+			Eventually(static.received).Should(Equal([]string{"log\n"}))
+			Expect(a.received()).To(BeEmpty())
+			Expect(w.Out.ReloadState().Unchanged).To(Equal(1))
+		})
+
+		It("reports files that can not be loaded", func() {
+			c := newCollector()
+			defer c.stop()
+			writeSinks("sinks: [{name: a, namespace: ns-a, addr: " + c.url() + "}]")
+			w := &syslog.SinksFileWatcher{Path: path}
+			sinks, clusterSinks, err := w.Load()
+			Expect(err).ToNot(HaveOccurred())
+			w.Out = syslog.NewOut(sinks, clusterSinks)
+
+			writeSinks("sinks: [{name: a, namespace: ns-a}]")
+			Expect(w.Check()).To(MatchError(ContainSubstring("addr is required")))
+			Expect(w.Out.ReloadState().Error).To(ContainSubstring("addr is required"))
+
+			w.Out.Write(record("ns-a", "log"), time.Unix(0, 0).UTC(), "pod.log")
+			Eventually(c.received).Should(Equal([]string{"log\n"}))
+		})
+	})
+})
@@ -0,0 +1,412 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	relpMaxTxnr    = 999999999
+	relpMaxDataLen = 1 << 24
+	relpOffers     = "relp_version=0\nrelp_software=fluent-bit-out-syslog\ncommands=syslog"
+)
+
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+// relpSession is a single RELP connection. It is broken once the server
+// closes the connection or the acknowledgement reader fails.
+type relpSession struct {
+	broken bool
+}
+
+// relpPending is a message that was sent but not yet acknowledged by the
+// server.
+type relpPending struct {
+	txnr    int
+	session *relpSession
+	data    []byte
+}
+
+// relpClient implements the client side of the Reliable Event Logging
+// Protocol (RELP). Every message is sent as a syslog command and kept until
+// the server acknowledges it. At most window messages are unacknowledged at
+// a time. Unacknowledged messages are sent again after reconnecting, which
+// results in at-least-once delivery.
+type relpClient struct {
+	sink   *Sink
+	out    *Out
+	window int
+
+	mu      sync.Mutex
+	txnr    int
+	session *relpSession
+	pending []*relpPending
+	notify  chan struct{}
+}
+
+// retainedError is returned by a transport when sending a message failed
+// but the message was kept to be sent again once the connection is
+// re-established.
+type retainedError struct {
+	error
+}
+
+func newRELPClient(s *Sink, out *Out) *relpClient {
+	return &relpClient{
+		sink:   s,
+		out:    out,
+		window: out.relpWindowSize,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (c *relpClient) maintainConn() error {
+	if c.sink.conn != nil {
+		return nil
+	}
+
+	conn, err := c.sink.connect(func(addr string) (net.Conn, error) {
+		if c.sink.TLS != nil {
+			return dialTLS(c.sink, addr)
+		}
+		return c.sink.dial("tcp", addr, c.sink.dialTimeout)
+	})
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	err = c.open(conn, r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	session := &relpSession{}
+	c.mu.Lock()
+	c.session = session
+	c.txnr = 1
+	for _, p := range c.pending {
+		p.txnr = c.nextTxnr()
+		p.session = session
+	}
+	pending := append([]*relpPending(nil), c.pending...)
+	c.mu.Unlock()
+
+	go c.readAcks(session, r)
+
+	_ = conn.SetWriteDeadline(time.Now().Add(c.sink.writeTimeout))
+	for _, p := range pending {
+		err = writeRELPFrame(conn, p.txnr, "syslog", p.data)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c.sink.conn = conn
+	return nil
+}
+
+// open performs the RELP open handshake on a new connection.
+func (c *relpClient) open(conn net.Conn, r *bufio.Reader) error {
+	_ = conn.SetDeadline(time.Now().Add(c.sink.dialTimeout))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	err := writeRELPFrame(conn, 1, "open", []byte(relpOffers))
+	if err != nil {
+		return err
+	}
+
+	f, err := readRELPFrame(r)
+	if err != nil {
+		return err
+	}
+	if f.command != "rsp" || f.txnr != 1 {
+		return fmt.Errorf("unexpected relp response to open: %d %s", f.txnr, f.command)
+	}
+	if status := relpStatus(f.data); !strings.HasPrefix(status, "200") {
+		return fmt.Errorf("relp server rejected open: %s", status)
+	}
+	return nil
+}
+
+func (c *relpClient) send(w io.WriterTo) error {
+	data, err := c.sink.marshal(w)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	broken := c.session.broken
+	c.mu.Unlock()
+	if broken {
+		// The server went away since the last message. Reconnect so that
+		// unacknowledged messages are sent again before this one.
+		c.sink.conn.Close()
+		c.sink.conn = nil
+		err = c.maintainConn()
+		if err != nil {
+			return c.retain(data, err)
+		}
+		_ = c.sink.conn.SetWriteDeadline(time.Now().Add(c.sink.writeTimeout))
+	}
+
+	err = c.waitForWindow()
+	if err != nil {
+		// The message is kept with the unacknowledged ones, which are sent
+		// again after reconnecting, instead of being dropped.
+		c.mu.Lock()
+		c.pending = append(c.pending, &relpPending{data: data})
+		c.mu.Unlock()
+		return retainedError{err}
+	}
+
+	c.mu.Lock()
+	p := &relpPending{
+		txnr:    c.nextTxnr(),
+		session: c.session,
+		data:    data,
+	}
+	c.pending = append(c.pending, p)
+	c.mu.Unlock()
+
+	err = writeRELPFrame(c.sink.conn, p.txnr, "syslog", data)
+	if err != nil {
+		return retainedError{err}
+	}
+	return nil
+}
+
+// retain keeps a message that could not be sent if there is room in the
+// window.
+func (c *relpClient) retain(data []byte, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) >= c.window {
+		return err
+	}
+	c.pending = append(c.pending, &relpPending{data: data})
+	return retainedError{err}
+}
+
+// waitForWindow blocks until fewer than window messages are unacknowledged,
+// at most for the sink's write timeout.
+func (c *relpClient) waitForWindow() error {
+	timer := time.NewTimer(c.sink.writeTimeout)
+	defer timer.Stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) >= c.window {
+		if c.session.broken {
+			return errors.New("relp connection lost with full window")
+		}
+
+		c.mu.Unlock()
+		select {
+		case <-c.notify:
+		case <-timer.C:
+			c.mu.Lock()
+			return errors.New("timed out waiting for relp acknowledgements")
+		}
+		c.mu.Lock()
+	}
+	return nil
+}
+
+// close waits up to the sink's write timeout for the server to acknowledge
+// the messages sent on the current connection and closes the connection.
+func (c *relpClient) close() {
+	if c.sink.conn == nil {
+		return
+	}
+
+	timer := time.NewTimer(c.sink.writeTimeout)
+	defer timer.Stop()
+wait:
+	for c.unacknowledged() {
+		select {
+		case <-c.notify:
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	c.mu.Lock()
+	txnr := c.nextTxnr()
+	c.mu.Unlock()
+	_ = c.sink.conn.SetWriteDeadline(time.Now().Add(c.sink.writeTimeout))
+	_ = writeRELPFrame(c.sink.conn, txnr, "close", nil)
+	c.sink.conn.Close()
+	c.sink.conn = nil
+}
+
+// unacknowledged reports whether messages sent on the current connection
+// were not acknowledged yet.
+func (c *relpClient) unacknowledged() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) != 0 && !c.session.broken
+}
+
+// readAcks reads responses from the server until the connection fails.
+func (c *relpClient) readAcks(session *relpSession, r *bufio.Reader) {
+	for {
+		f, err := readRELPFrame(r)
+		if err != nil {
+			if err == io.EOF {
+				c.storeError("relp server closed the connection")
+			}
+			c.breakSession(session)
+			return
+		}
+
+		switch f.command {
+		case "rsp":
+			c.ack(session, f)
+		case "serverclose":
+			c.storeError("relp server closed the connection")
+			c.breakSession(session)
+			return
+		}
+	}
+}
+
+func (c *relpClient) ack(session *relpSession, f relpFrame) {
+	c.mu.Lock()
+	for i, p := range c.pending {
+		if p.session == session && p.txnr == f.txnr {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if status := relpStatus(f.data); !strings.HasPrefix(status, "200") {
+		atomic.AddInt64(&c.sink.messagesDropped, 1)
+		c.storeError(fmt.Sprintf("relp server rejected message: %s", status))
+	}
+	c.signal()
+}
+
+func (c *relpClient) breakSession(session *relpSession) {
+	c.mu.Lock()
+	session.broken = true
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *relpClient) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *relpClient) storeError(msg string) {
+	c.sink.writeErr.Store(SinkError{
+		Msg:       msg,
+		Timestamp: time.Now(),
+	})
+}
+
+// nextTxnr returns the next transaction number. It must be called with mu
+// held.
+func (c *relpClient) nextTxnr() int {
+	c.txnr++
+	if c.txnr > relpMaxTxnr {
+		c.txnr = 1
+	}
+	return c.txnr
+}
+
+// relpStatus returns the first line of a rsp frame's data which holds the
+// status code and a human readable message.
+func relpStatus(data []byte) string {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func writeRELPFrame(w io.Writer, txnr int, command string, data []byte) error {
+	var buf bytes.Buffer
+	if len(data) == 0 {
+		fmt.Fprintf(&buf, "%d %s 0\n", txnr, command)
+	} else {
+		fmt.Fprintf(&buf, "%d %s %d ", txnr, command, len(data))
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readRELPFrame(r *bufio.Reader) (relpFrame, error) {
+	var f relpFrame
+
+	txnr, err := r.ReadString(' ')
+	if err != nil {
+		return f, err
+	}
+	f.txnr, err = strconv.Atoi(strings.TrimSuffix(txnr, " "))
+	if err != nil {
+		return f, fmt.Errorf("invalid relp transaction number: %s", err)
+	}
+
+	command, err := r.ReadString(' ')
+	if err != nil {
+		return f, err
+	}
+	f.command = strings.TrimSuffix(command, " ")
+
+	var dataLen int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return f, err
+		}
+		if b == '\n' && dataLen == 0 {
+			return f, nil
+		}
+		if b == ' ' {
+			break
+		}
+		if b < '0' || b > '9' || dataLen > relpMaxDataLen {
+			return f, fmt.Errorf("invalid relp data length")
+		}
+		dataLen = dataLen*10 + int(b-'0')
+	}
+
+	f.data = make([]byte, dataLen)
+	_, err = io.ReadFull(r, f.data)
+	if err != nil {
+		return f, err
+	}
+
+	trailer, err := r.ReadByte()
+	if err != nil {
+		return f, err
+	}
+	if trailer != '\n' {
+		return f, fmt.Errorf("invalid relp frame trailer")
+	}
+	return f, nil
+}
@@ -0,0 +1,251 @@
+package syslog_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+// relpServer is an in-process stand-in for a RELP server. Each accepted
+// connection is driven explicitly by the test.
+type relpServer struct {
+	lis net.Listener
+}
+
+type relpServerConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type relpTestFrame struct {
+	txnr    int
+	command string
+	data    string
+}
+
+func newRELPServer(addr ...string) *relpServer {
+	a := "127.0.0.1:0"
+	if len(addr) != 0 {
+		a = addr[0]
+	}
+	lis, err := net.Listen("tcp", a)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return &relpServer{lis: lis}
+}
+
+func (s *relpServer) url() string {
+	return s.lis.Addr().String()
+}
+
+func (s *relpServer) stop() {
+	_ = s.lis.Close()
+}
+
+// accept accepts a connection and completes the open handshake.
+func (s *relpServer) accept() *relpServerConn {
+	conn, err := s.lis.Accept()
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	c := &relpServerConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	f := c.read()
+	ExpectWithOffset(1, f.command).To(Equal("open"))
+	ExpectWithOffset(1, f.data).To(ContainSubstring("commands=syslog"))
+	c.respond(f.txnr, "200 OK\nrelp_version=0\ncommands=syslog")
+	return c
+}
+
+func (c *relpServerConn) read() relpTestFrame {
+	var f relpTestFrame
+
+	txnr, err := c.r.ReadString(' ')
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	f.txnr, err = strconv.Atoi(strings.TrimSpace(txnr))
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	command, err := c.r.ReadString(' ')
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	f.command = strings.TrimSpace(command)
+
+	length, err := c.r.ReadString(' ')
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	data := make([]byte, n+1)
+	_, err = io.ReadFull(c.r, data)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	ExpectWithOffset(1, data[n]).To(Equal(byte('\n')))
+	f.data = string(data[:n])
+
+	return f
+}
+
+func (c *relpServerConn) respond(txnr int, status string) {
+	_, err := fmt.Fprintf(c.conn, "%d rsp %d %s\n", txnr, len(status), status)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+}
+
+func (c *relpServerConn) close() {
+	_ = c.conn.Close()
+}
+
+var _ = Describe("RELP", func() {
+	var (
+		server *relpServer
+		record func(msg string) map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		server = newRELPServer()
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("ns1"),
+				},
+			}
+		}
+	})
+
+	AfterEach(func() {
+		server.stop()
+	})
+
+	expected := func(msg string) string {
+		return `<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1// - - [kubernetes@47450 namespace_name="ns1" object_name="" container_name=""] ` + msg + "\n"
+	}
+
+	It("sends messages as syslog commands", func() {
+		s := &syslog.Sink{
+			Addr:      server.url(),
+			Namespace: "ns1",
+			Name:      "sink-name",
+			Transport: syslog.TransportRELP,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn := server.accept()
+		defer conn.close()
+
+		f := conn.read()
+		Expect(f.command).To(Equal("syslog"))
+		Expect(f.txnr).To(Equal(2))
+		Expect(f.data).To(Equal(expected("some-log")))
+		conn.respond(f.txnr, "200 OK")
+
+		Eventually(func() *syslog.SinkError {
+			return out.SinkState()[0].Error
+		}).Should(BeNil())
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("resends unacknowledged messages after reconnecting", func() {
+		s := &syslog.Sink{
+			Addr:      server.url(),
+			Namespace: "ns1",
+			Name:      "sink-name",
+			Transport: syslog.TransportRELP,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log-1"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn := server.accept()
+		f := conn.read()
+		Expect(f.data).To(Equal(expected("some-log-1")))
+		conn.close()
+
+		Eventually(func() *syslog.SinkError {
+			return out.SinkState()[0].Error
+		}).ShouldNot(BeNil())
+
+		out.Write(record("some-log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn = server.accept()
+		defer conn.close()
+
+		f1 := conn.read()
+		Expect(f1.data).To(Equal(expected("some-log-1")))
+		f2 := conn.read()
+		Expect(f2.data).To(Equal(expected("some-log-2")))
+		Expect(f2.txnr).To(Equal(f1.txnr + 1))
+		conn.respond(f1.txnr, "200 OK")
+		conn.respond(f2.txnr, "200 OK")
+
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("counts messages rejected by the server as dropped", func() {
+		s := &syslog.Sink{
+			Addr:      server.url(),
+			Namespace: "ns1",
+			Transport: syslog.TransportRELP,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("some-log"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn := server.accept()
+		defer conn.close()
+
+		f := conn.read()
+		conn.respond(f.txnr, "500 error")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(Equal("relp server rejected message: 500 error"))
+	})
+
+	It("keeps messages when the window is not acknowledged in time", func() {
+		s := &syslog.Sink{
+			Addr:      server.url(),
+			Namespace: "ns1",
+			Transport: syslog.TransportRELP,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithRELPWindowSize(1),
+			syslog.WithWriteTimeout(100*time.Millisecond),
+		)
+
+		out.Write(record("some-log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("some-log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn := server.accept()
+		f := conn.read()
+		Expect(f.data).To(Equal(expected("some-log-1")))
+
+		Eventually(func() *syslog.SinkError {
+			return out.SinkState()[0].Error
+		}).ShouldNot(BeNil())
+		Expect(out.SinkState()[0].Error.Msg).To(Equal("timed out waiting for relp acknowledgements"))
+		conn.close()
+
+		out.Write(record("some-log-3"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn = server.accept()
+		defer conn.close()
+		for _, msg := range []string{"some-log-1", "some-log-2", "some-log-3"} {
+			f := conn.read()
+			Expect(f.data).To(Equal(expected(msg)))
+			conn.respond(f.txnr, "200 OK")
+		}
+
+		Consistently(s.MessagesDropped, 100*time.Millisecond).Should(BeZero())
+	})
+})
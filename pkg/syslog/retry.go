@@ -0,0 +1,43 @@
+package syslog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// retry reports whether a message that failed to send is sent again. It is
+// given the number of times the message was already retried and the time
+// it was first attempted. Once the reconnect backoff of the sink allows it
+// to connect again retry waits for it. Messages of relp and https sinks
+// are not retried since these transports already send them again.
+func (s *Sink) retry(retries int, start time.Time) bool {
+	if retries >= s.retryAttempts {
+		return false
+	}
+	switch s.Transport {
+	case TransportRELP, TransportHTTPS:
+		return false
+	}
+
+	next := time.Unix(0, atomic.LoadInt64(&s.nextRetryNanos))
+	if s.retryMaxAge > 0 {
+		deadline := start.Add(s.retryMaxAge)
+		if time.Now().After(deadline) || next.After(deadline) {
+			return false
+		}
+	}
+	time.Sleep(time.Until(next))
+
+	atomic.AddInt64(&s.messagesRetried, 1)
+	return true
+}
+
+// MessagesRetried returns the number of times messages were sent again
+// after failing to send.
+func (s *Sink) MessagesRetried() int64 {
+	mr := atomic.LoadInt64(&s.messagesRetried)
+	for _, w := range s.workers {
+		mr += w.MessagesRetried()
+	}
+	return mr
+}
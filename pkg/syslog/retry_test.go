@@ -0,0 +1,137 @@
+package syslog_test
+
+import (
+	"bufio"
+	"net"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		lis    net.Listener
+		record func(msg string) map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		record = func(msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+				},
+			}
+		}
+	})
+
+	AfterEach(func() {
+		_ = lis.Close()
+	})
+
+	// read returns the next message received on conn.
+	read := func(conn net.Conn) string {
+		var msg rfc5424.Message
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err := msg.ReadFrom(bufio.NewReader(conn))
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return string(msg.Message)
+	}
+
+	// reset accepts the sink's connection, reads the first message and
+	// resets the connection so that the next message fails to send.
+	reset := func(out *syslog.Out) {
+		out.Write(record("log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		conn, err := lis.Accept()
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		ExpectWithOffset(1, read(conn)).To(Equal("log-1\n"))
+
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	It("sends a message that failed to send again on a new connection", func() {
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+		reset(out)
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		conn, err := lis.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(read(conn)).To(Equal("log-2\n"))
+		Expect(s.MessagesRetried()).To(Equal(int64(1)))
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("drops a message once it was retried the configured number of times", func() {
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithRetry(2, 0),
+			syslog.WithReconnectBackoff(10*time.Millisecond, 10*time.Millisecond),
+		)
+		reset(out)
+		Expect(lis.Close()).To(Succeed())
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(s.MessagesRetried()).To(Equal(int64(2)))
+	})
+
+	It("drops a message once it is older than the maximum age", func() {
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithRetry(10, 50*time.Millisecond),
+			syslog.WithReconnectBackoff(200*time.Millisecond, 200*time.Millisecond),
+		)
+		reset(out)
+		Expect(lis.Close()).To(Succeed())
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(s.MessagesRetried()).To(Equal(int64(1)))
+	})
+
+	It("does not retry messages when retries are disabled", func() {
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithRetry(0, 0),
+		)
+		reset(out)
+
+		out.Write(record("log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(s.MessagesRetried()).To(BeZero())
+	})
+})
@@ -0,0 +1,132 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+)
+
+// Formats a sink renders messages in. An empty format is treated as
+// RFC 5424.
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatRFC3164 = "rfc3164"
+)
+
+// TAG is limited to 32 chars in RFC 3164
+// https://tools.ietf.org/html/rfc3164#section-4.1.3
+const maxTagLength = 32
+
+// defaultTag is the tag of messages without a container or pod name.
+const defaultTag = "fluentbit"
+
+var (
+	localHostnameOnce sync.Once
+	localHostname     string
+)
+
+// foldedParams are the structured data parameters that are kept in the
+// message body of RFC 3164 messages since RFC 3164 has no structured data.
+var foldedParams = map[string]bool{
+	"namespace_name": true,
+	"object_name":    true,
+	"container_name": true,
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case "", FormatRFC5424, FormatRFC3164:
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// marshalRFC3164 renders the message as a BSD syslog message in the form
+// "<PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". RFC 3164 has no NILVALUE,
+// messages without a hostname have the hostname of the plugin and messages
+// without a container or pod name the default tag.
+func marshalRFC3164(m *rfc5424.Message) []byte {
+	var buf bytes.Buffer
+
+	hostname := m.Hostname
+	if hostname == "" {
+		hostname = hostnameRFC3164()
+	}
+	var container, pod string
+	for _, sd := range m.StructuredData {
+		for _, p := range sd.Parameters {
+			switch p.Name {
+			case "container_name":
+				container = p.Value
+			case "object_name":
+				pod = p.Value
+			}
+		}
+	}
+	tag := tagRFC3164(container)
+	if tag == "" {
+		tag = tagRFC3164(pod)
+	}
+	if tag == "" {
+		tag = defaultTag
+	}
+	fmt.Fprintf(
+		&buf,
+		"<%d>%s %s %s",
+		m.Priority,
+		m.Timestamp.Format(time.Stamp),
+		hostname,
+		tag,
+	)
+	if m.ProcessID != "" {
+		fmt.Fprintf(&buf, "[%s]", m.ProcessID)
+	}
+	buf.WriteString(": ")
+
+	var folded []string
+	for _, sd := range m.StructuredData {
+		for _, p := range sd.Parameters {
+			if foldedParams[p.Name] {
+				folded = append(folded, fmt.Sprintf(`%s="%s"`, p.Name, p.Value))
+			}
+		}
+	}
+	if len(folded) != 0 {
+		fmt.Fprintf(&buf, "[%s] ", strings.Join(folded, " "))
+	}
+
+	buf.Write(m.Message)
+	return buf.Bytes()
+}
+
+// tagRFC3164 returns the name without the characters that are not
+// alphanumeric, which end the TAG in RFC 3164, truncated to 32 characters.
+// The namespace, pod and container names are in the message body, so the
+// tag is built from the container or pod name alone.
+func tagRFC3164(name string) string {
+	tag := make([]byte, 0, maxTagLength)
+	for i := 0; i < len(name) && len(tag) < maxTagLength; i++ {
+		c := name[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			tag = append(tag, c)
+		}
+	}
+	return string(tag)
+}
+
+// hostnameRFC3164 returns the hostname of the plugin or localhost if it is
+// unknown.
+func hostnameRFC3164() string {
+	localHostnameOnce.Do(func() {
+		localHostname, _ = os.Hostname()
+		if localHostname == "" {
+			localHostname = "localhost"
+		}
+	})
+	return localHostname
+}
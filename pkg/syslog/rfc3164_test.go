@@ -0,0 +1,103 @@
+package syslog_test
+
+import (
+	"net"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("RFC 3164", func() {
+	var record map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"labels": map[interface{}]interface{}{
+					"component": []byte("some-component"),
+				},
+				"namespace_name": []byte("ns1"),
+				"pod_name":       []byte("pod-name"),
+				"container_name": []byte("container-name"),
+				"host":           []byte("some-host"),
+			},
+		}
+	})
+
+	It("writes BSD syslog messages with the alphanumeric container name as tag", func() {
+		spySink := newSpySink()
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "ns1",
+			Format:    syslog.FormatRFC3164,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Date(2019, time.March, 7, 13, 4, 5, 0, time.UTC), "pod.log")
+
+		spySink.expectReceived(
+			`<14>Mar  7 13:04:05 some-host containername: [namespace_name="ns1" object_name="pod-name" container_name="container-name"] some-log` + "\n",
+		)
+	})
+
+	It("uses the pod name as tag of messages without a container name", func() {
+		spySink := newSpySink()
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "ns1",
+			Format:    syslog.FormatRFC3164,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+		delete(record["kubernetes"].(map[interface{}]interface{}), "container_name")
+
+		out.Write(record, time.Date(2019, time.March, 7, 13, 4, 5, 0, time.UTC), "pod.log")
+
+		spySink.expectReceived(
+			`<14>Mar  7 13:04:05 some-host podname: [namespace_name="ns1" object_name="pod-name" container_name=""] some-log` + "\n",
+		)
+	})
+
+	It("writes BSD syslog messages without kubernetes metadata", func() {
+		hostname, err := os.Hostname()
+		Expect(err).ToNot(HaveOccurred())
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		s := &syslog.Sink{
+			Addr:      conn.LocalAddr().String(),
+			Transport: syslog.TransportUDP,
+			Format:    syslog.FormatRFC3164,
+		}
+		out := syslog.NewOut(nil, []*syslog.Sink{s})
+
+		out.Write(
+			map[interface{}]interface{}{"log": []byte("some-log")},
+			time.Date(2019, time.December, 24, 1, 2, 3, 0, time.UTC),
+			"pod.log",
+		)
+
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf[:n])).To(Equal(`<14>Dec 24 01:02:03 ` + hostname + ` fluentbit: [namespace_name="" object_name="" container_name=""] some-log` + "\n"))
+	})
+
+	It("rejects unknown formats", func() {
+		s := &syslog.Sink{
+			Format: "rfc1149",
+		}
+		Expect(s.Validate()).To(MatchError(`unsupported format "rfc1149"`))
+	})
+})
@@ -0,0 +1,142 @@
+package syslog
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// Close stops Write from queueing messages and waits until the sinks sent
+// the messages left in their queues or ctx is done. Sinks that did not
+// finish by then abandon their remaining messages. The connections of all
+// sinks are closed. Close logs how many messages each sink sent and
+// abandoned and returns ctx's error if any sink did not finish in time.
+func (o *Out) Close(ctx context.Context) error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	sinks := o.allSinks()
+	o.mu.Unlock()
+
+	return drain(ctx, sinks)
+}
+
+// drain stops the sinks, which must no longer receive messages, and waits
+// until they sent their queued messages or ctx is done.
+func drain(ctx context.Context, sinks []*Sink) error {
+	sent := make([]int64, len(sinks))
+	dropped := make([]int64, len(sinks))
+	for i, s := range sinks {
+		sent[i] = s.MessagesSent()
+		dropped[i] = s.MessagesDropped()
+		s.stop()
+	}
+
+	var err error
+	for _, s := range sinks {
+		if !s.wait(ctx) {
+			err = ctx.Err()
+		}
+		if s.diskQueue != nil {
+			// An aborted sink stops after its current message. The
+			// messages it did not send are kept in the disk queue, which
+			// is closed once the sink no longer uses it so that another
+			// sink may open its directory.
+			<-s.done
+			s.persist()
+			s.diskQueue.close()
+		}
+	}
+
+	for i, s := range sinks {
+		flushed := s.MessagesSent() - sent[i]
+		abandoned := s.queued() + s.MessagesDropped() - dropped[i]
+		if s.diskQueue != nil {
+			kept, _ := s.diskQueue.stats()
+			log.Printf("Sink to address %s, at namespace [%s] closed: %d messages flushed, %d abandoned, %d kept in disk queue\n", s.Addr, s.Namespace, flushed, abandoned, kept)
+			continue
+		}
+		log.Printf("Sink to address %s, at namespace [%s] closed: %d messages flushed, %d abandoned\n", s.Addr, s.Namespace, flushed, abandoned)
+	}
+	return err
+}
+
+func (o *Out) allSinks() []*Sink {
+	var sinks []*Sink
+	for _, ss := range o.sinks {
+		sinks = append(sinks, ss...)
+	}
+	return append(sinks, o.clusterSinks...)
+}
+
+// MessagesSent returns the number of messages the sink sent.
+func (s *Sink) MessagesSent() int64 {
+	ms := atomic.LoadInt64(&s.messagesSent)
+	for _, w := range s.workers {
+		ms += w.MessagesSent()
+	}
+	return ms
+}
+
+// runners returns the sinks that run a goroutine writing messages, which
+// are either the workers of s or s itself.
+func (s *Sink) runners() []*Sink {
+	if len(s.workers) != 0 {
+		return s.workers
+	}
+	return []*Sink{s}
+}
+
+// stop closes the sink's queues so that its goroutines exit once they
+// wrote the queued messages.
+func (s *Sink) stop() {
+	if s.ShardByPod && len(s.workers) != 0 {
+		for _, w := range s.workers {
+			close(w.messages)
+		}
+		return
+	}
+	close(s.messages)
+}
+
+// wait waits until the sink's goroutines exited or ctx is done. In the
+// latter case the goroutines are aborted and wait reports false.
+func (s *Sink) wait(ctx context.Context) bool {
+	finished := true
+	for _, r := range s.runners() {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			close(r.abort)
+			finished = false
+		}
+	}
+	return finished
+}
+
+// queued returns the number of messages left in the sink's queues.
+func (s *Sink) queued() int64 {
+	if s.ShardByPod && len(s.workers) != 0 {
+		var n int64
+		for _, w := range s.workers {
+			n += int64(len(w.messages))
+		}
+		return n
+	}
+	return int64(len(s.messages))
+}
+
+// closeConnection closes the connection of the sink.
+func (s *Sink) closeConnection() {
+	if s.disconnect != nil {
+		s.disconnect()
+		return
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
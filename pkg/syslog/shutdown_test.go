@@ -0,0 +1,131 @@
+package syslog_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Close", func() {
+	var record func(pod, msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(pod, msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+					"pod_name":       []byte(pod),
+				},
+			}
+		}
+	})
+
+	It("sends the queued messages before it returns", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+		}
+		cs := &syslog.Sink{
+			Addr:    c.url(),
+			Workers: 2,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, []*syslog.Sink{cs})
+
+		for i := 0; i < 100; i++ {
+			Expect(out.Write(record("pod", "log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")).To(BeTrue())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		Expect(out.Close(ctx)).To(Succeed())
+
+		Expect(s.MessagesSent()).To(Equal(int64(100)))
+		Expect(cs.MessagesSent()).To(Equal(int64(100)))
+		Eventually(c.received).Should(HaveLen(200))
+	})
+
+	It("stops queueing messages", func() {
+		c := newCollector()
+		defer c.stop()
+		s := &syslog.Sink{
+			Addr:       c.url(),
+			Namespace:  "some-ns",
+			Workers:    2,
+			ShardByPod: true,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		Expect(out.Close(context.Background())).To(Succeed())
+		Expect(out.Close(context.Background())).To(Succeed())
+
+		Expect(out.Write(record("pod", "log"), time.Unix(0, 0).UTC(), "pod.log")).To(BeFalse())
+		Consistently(c.received, 100*time.Millisecond).Should(BeEmpty())
+		Expect(s.MessagesDropped()).To(BeZero())
+	})
+
+	It("closes the connections of the sinks", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("pod", "log"), time.Unix(0, 0).UTC(), "pod.log")
+		conn, err := lis.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msg rfc5424.Message
+		_, err = msg.ReadFrom(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.Close(context.Background())).To(Succeed())
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("abandons the queued messages once the context is done", func() {
+		// The listener never completes a TLS handshake so the sink is
+		// blocked until its dial timeout.
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+		s := &syslog.Sink{
+			Addr:      lis.Addr().String(),
+			Namespace: "some-ns",
+			TLS:       &syslog.TLS{InsecureSkipVerify: true},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithDialTimeout(2*time.Second),
+		)
+		for i := 0; i < 10; i++ {
+			out.Write(record("pod", "log-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		Expect(out.Close(ctx)).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(s.MessagesSent()).To(BeZero())
+	})
+})
@@ -3,7 +3,9 @@ package syslog_test
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"time"
@@ -11,6 +13,8 @@ import (
 	"code.cloudfoundry.org/rfc5424"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
 )
 
 type spySink struct {
@@ -38,6 +42,52 @@ func newTLSSpySink(addr ...string) *spySink {
 	}
 }
 
+func newTLSSpySinkWithConfig(config *tls.Config, addr ...string) *spySink {
+	a := ":0"
+	if len(addr) != 0 {
+		a = addr[0]
+	}
+
+	cert, err := tls.LoadX509KeyPair("./testdata/server.crt", "./testdata/server.key")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	config.Certificates = []tls.Certificate{cert}
+
+	lis, err := tls.Listen("tcp", a, config)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	return &spySink{
+		lis: lis,
+	}
+}
+
+func newMutualTLSSpySink(addr ...string) *spySink {
+	a := ":0"
+	if len(addr) != 0 {
+		a = addr[0]
+	}
+
+	cert, err := tls.LoadX509KeyPair("./testdata/server.crt", "./testdata/server.key")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	pem, err := ioutil.ReadFile("./testdata/rootCA.crt")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	clientCAs := x509.NewCertPool()
+	ExpectWithOffset(1, clientCAs.AppendCertsFromPEM(pem)).To(BeTrue())
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+
+	lis, err := tls.Listen("tcp", a, config)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	return &spySink{
+		lis: lis,
+	}
+}
+
 func newSpySink(addr ...string) *spySink {
 	a := ":0"
 	if len(addr) != 0 {
@@ -176,3 +226,12 @@ func (s *spySink) expectReceivedWithSD(sds ...[]rfc5424.StructuredData) {
 		}
 	}
 }
+
+// waitForRetry waits until the first sink of out may try to connect again
+// after failing to connect.
+func waitForRetry(out *syslog.Out) {
+	EventuallyWithOffset(1, func() bool {
+		next := out.SinkState()[0].NextRetry
+		return next == nil || time.Now().After(*next)
+	}, 5*time.Second).Should(BeTrue())
+}
@@ -0,0 +1,285 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type TLS struct {
+	InsecureSkipVerify bool     `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	RootCA             string   `json:"root_ca" yaml:"root_ca"`
+	Cert               string   `json:"cert" yaml:"cert"`
+	Key                string   `json:"key" yaml:"key"`
+	ServerName         string   `json:"server_name" yaml:"server_name"`
+	MinVersion         string   `json:"min_version" yaml:"min_version"`
+	MaxVersion         string   `json:"max_version" yaml:"max_version"`
+	CipherSuites       []string `json:"cipher_suites" yaml:"cipher_suites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuites are the configurable cipher suites. TLS 1.3 cipher suites
+// are not configurable.
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":        tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":          tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":     tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+func validateTLS(t *TLS) error {
+	if t == nil {
+		return nil
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("tls cert and key must be provided together")
+	}
+	_, err := tlsPolicy(t)
+	return err
+}
+
+// tlsPolicy returns a tls.Config with the server name, protocol versions
+// and cipher suites of the TLS configuration.
+func tlsPolicy(t *TLS) (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls min_version %q", t.MinVersion)
+		}
+		c.MinVersion = v
+	}
+	if t.MaxVersion != "" {
+		v, ok := tlsVersions[t.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls max_version %q", t.MaxVersion)
+		}
+		c.MaxVersion = v
+	}
+	if c.MinVersion != 0 && c.MaxVersion != 0 && c.MinVersion > c.MaxVersion {
+		return nil, fmt.Errorf("tls min_version %s is greater than max_version %s", t.MinVersion, t.MaxVersion)
+	}
+
+	for _, name := range t.CipherSuites {
+		id, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls cipher suite %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+	if len(c.CipherSuites) != 0 && c.MinVersion == tls.VersionTLS13 {
+		return nil, errors.New("tls cipher suites can not be configured for tls 1.3")
+	}
+
+	return c, nil
+}
+
+func tlsMaintainConn(s *Sink) func() error {
+	return func() error {
+		if s.conn == nil {
+			conn, err := s.connect(func(addr string) (net.Conn, error) {
+				return dialTLS(s, addr)
+			})
+			if err == nil {
+				s.conn = conn
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// dialTLS establishes a TLS connection to addr using the sink's TLS
+// configuration. The CA and client certificate files are read on
+// every dial so that rotated files are picked up.
+func dialTLS(s *Sink, addr string) (net.Conn, error) {
+	config, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = s.serverName(addr)
+	}
+
+	conn, err := s.dial("tcp", addr, s.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, config)
+	_ = tlsConn.SetDeadline(time.Now().Add(s.dialTimeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// tlsConfig returns the sink's TLS policy together with the CA and client
+// certificates currently on disk.
+func (s *Sink) tlsConfig() (*tls.Config, error) {
+	config, err := tlsPolicy(s.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	s.tlsFingerprint = tlsFingerprint(s.TLS)
+	m, err := loadTLSMaterial(s.TLS)
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(&s.clientCertExpiryNanos, unixNano(m.clientCertExpiry))
+	atomic.StoreInt64(&s.rootCAExpiryNanos, unixNano(m.rootCAExpiry))
+
+	config.RootCAs = m.roots
+	config.Certificates = m.certs
+	return config, nil
+}
+
+// tlsMaterial holds the CA and client certificates read from disk.
+type tlsMaterial struct {
+	roots            *x509.CertPool
+	certs            []tls.Certificate
+	rootCAExpiry     time.Time
+	clientCertExpiry time.Time
+}
+
+func loadTLSMaterial(t *TLS) (*tlsMaterial, error) {
+	m := &tlsMaterial{}
+
+	if !t.InsecureSkipVerify && t.RootCA != "" {
+		bundle, err := ioutil.ReadFile(t.RootCA)
+		if err != nil {
+			return nil, err
+		}
+
+		m.roots = x509.NewCertPool()
+		if ok := m.roots.AppendCertsFromPEM(bundle); !ok {
+			return nil, fmt.Errorf("append certificate failed")
+		}
+		m.rootCAExpiry = earliestExpiry(bundle)
+	}
+
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		m.certs = append(m.certs, cert)
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil {
+			m.clientCertExpiry = leaf.NotAfter
+		}
+	}
+
+	return m, nil
+}
+
+// earliestExpiry returns the earliest expiry of the certificates in the PEM
+// bundle.
+func earliestExpiry(bundle []byte) time.Time {
+	var expiry time.Time
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return expiry
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+}
+
+// tlsFingerprint identifies the current version of the CA and client
+// certificate files by their size and modification time. Kubernetes
+// replaces the symlinks of mounted secrets on rotation which is picked up
+// since os.Stat follows symlinks.
+func tlsFingerprint(t *TLS) string {
+	var fp []string
+	for _, path := range []string{t.RootCA, t.Cert, t.Key} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fp = append(fp, path+":missing")
+			continue
+		}
+		fp = append(fp, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(fp, ",")
+}
+
+// reloadTLS re-establishes the sink's connection if the CA or client
+// certificate files changed since the connection was established.
+func (s *Sink) reloadTLS() {
+	if s.conn == nil || tlsFingerprint(s.TLS) == s.tlsFingerprint {
+		return
+	}
+
+	log.Printf("Sink to address %s, at namespace [%s] reconnecting after TLS files changed\n", s.Addr, s.Namespace)
+	s.conn.Close()
+	s.conn = nil
+
+	err := s.maintainConnection()
+	if err != nil {
+		s.storeError(err)
+	}
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
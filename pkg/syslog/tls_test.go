@@ -0,0 +1,378 @@
+package syslog_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Mutual TLS", func() {
+	var record map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("some-ns"),
+			},
+		}
+	})
+
+	It("writes messages using a client certificate", func() {
+		spySink := newMutualTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA: "./testdata/rootCA.crt",
+				Cert:   "./testdata/client.crt",
+				Key:    "./testdata/client.key",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		// TLS will block on waiting for handshake so the write needs
+		// to occur in a separate go routine
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+
+		spySink.expectReceivedOnly(
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/some-ns// - - [kubernetes@47450 namespace_name="some-ns" object_name="" container_name=""] some-log` + "\n",
+		)
+	})
+
+	It("reports client certificates that can not be loaded", func() {
+		spySink := newMutualTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			Name:      "sink-name",
+			TLS: &syslog.TLS{
+				RootCA: "./testdata/rootCA.crt",
+				Cert:   "./testdata/client.crt",
+				Key:    "./testdata/does-not-exist.key",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(HavePrefix("unable to load client certificate: open ./testdata/does-not-exist.key"))
+	})
+
+	It("requires both a certificate and a key", func() {
+		s := &syslog.Sink{
+			TLS: &syslog.TLS{
+				Cert: "./testdata/client.crt",
+			},
+		}
+		Expect(s.Validate()).To(MatchError("tls cert and key must be provided together"))
+	})
+})
+
+var _ = Describe("TLS policy", func() {
+	var record map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("some-ns"),
+			},
+		}
+	})
+
+	It("verifies the server against the configured server name", func() {
+		spySink := newTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA:     "./testdata/rootCA.crt",
+				ServerName: "localhost",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+
+		spySink.expectReceivedOnly(
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/some-ns// - - [kubernetes@47450 namespace_name="some-ns" object_name="" container_name=""] some-log` + "\n",
+		)
+	})
+
+	It("fails when the server name does not match", func() {
+		spySink := newTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+		go func() {
+			conn, err := spySink.lis.Accept()
+			if err == nil {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA:     "./testdata/rootCA.crt",
+				ServerName: "some-other-host",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(ContainSubstring("some-other-host"))
+	})
+
+	It("fails when the server does not support the minimum version", func() {
+		spySink := newTLSSpySinkWithConfig(&tls.Config{
+			MaxVersion: tls.VersionTLS12,
+		})
+		defer spySink.stop()
+		go func() {
+			conn, err := spySink.lis.Accept()
+			if err == nil {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				InsecureSkipVerify: true,
+				MinVersion:         "1.3",
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(ContainSubstring("protocol version"))
+	})
+
+	It("negotiates one of the configured cipher suites", func() {
+		spySink := newTLSSpySinkWithConfig(&tls.Config{
+			MaxVersion: tls.VersionTLS12,
+		})
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				InsecureSkipVerify: true,
+				CipherSuites:       []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+
+		conn := spySink.accept()
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		Expect(tlsConn.Handshake()).To(Succeed())
+
+		Expect(tlsConn.ConnectionState().CipherSuite).To(Equal(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384))
+	})
+
+	DescribeTable(
+		"validates the policy",
+		func(t syslog.TLS, expectedErr string) {
+			s := &syslog.Sink{
+				TLS: &t,
+			}
+			err := s.Validate()
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("valid policy", syslog.TLS{
+			ServerName:   "localhost",
+			MinVersion:   "1.2",
+			MaxVersion:   "1.3",
+			CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		}, ""),
+		Entry("unknown min version", syslog.TLS{MinVersion: "2.0"}, `unsupported tls min_version "2.0"`),
+		Entry("unknown max version", syslog.TLS{MaxVersion: "TLS1.2"}, `unsupported tls max_version "TLS1.2"`),
+		Entry("min version greater than max version", syslog.TLS{MinVersion: "1.3", MaxVersion: "1.2"}, "tls min_version 1.3 is greater than max_version 1.2"),
+		Entry("unknown cipher suite", syslog.TLS{CipherSuites: []string{"TLS_NOPE"}}, `unsupported tls cipher suite "TLS_NOPE"`),
+		Entry("cipher suites with tls 1.3", syslog.TLS{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, "tls cipher suites can not be configured for tls 1.3"),
+	)
+})
+
+var _ = Describe("TLS reload", func() {
+	var (
+		dir    string
+		record map[interface{}]interface{}
+	)
+
+	copyFile := func(src, dst string) {
+		b, err := ioutil.ReadFile(src)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		ExpectWithOffset(1, ioutil.WriteFile(dst, b, 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "out-syslog-tls")
+		Expect(err).ToNot(HaveOccurred())
+
+		copyFile("./testdata/rootCA.crt", filepath.Join(dir, "ca.crt"))
+		copyFile("./testdata/client.crt", filepath.Join(dir, "client.crt"))
+		copyFile("./testdata/client.key", filepath.Join(dir, "client.key"))
+
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("some-ns"),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reports the expiry of the loaded certificates", func() {
+		spySink := newMutualTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA: filepath.Join(dir, "ca.crt"),
+				Cert:   filepath.Join(dir, "client.crt"),
+				Key:    filepath.Join(dir, "client.key"),
+			},
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+		spySink.expectReceived(
+			`<14>1 1970-01-01T00:00:00+00:00 - pod.log/some-ns// - - [kubernetes@47450 namespace_name="some-ns" object_name="" container_name=""] some-log` + "\n",
+		)
+
+		state := out.SinkState()[0]
+		Expect(state.ClientCertExpiry).ToNot(BeNil())
+		Expect(state.ClientCertExpiry.UTC()).To(Equal(time.Date(2051, time.June, 7, 18, 32, 14, 0, time.UTC)))
+		Expect(state.RootCAExpiry).ToNot(BeNil())
+		Expect(state.RootCAExpiry.UTC()).To(Equal(time.Date(2045, time.December, 7, 16, 20, 10, 0, time.UTC)))
+	})
+
+	It("reconnects when the certificate files change", func() {
+		spySink := newMutualTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA: filepath.Join(dir, "ca.crt"),
+				Cert:   filepath.Join(dir, "client.crt"),
+				Key:    filepath.Join(dir, "client.key"),
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithTLSReloadInterval(50*time.Millisecond),
+		)
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+		conn := spySink.accept()
+		defer conn.Close()
+		Expect(conn.(*tls.Conn).Handshake()).To(Succeed())
+
+		rotated := time.Now().Add(time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, "client.crt"), rotated, rotated)).To(Succeed())
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := spySink.lis.Accept()
+			if err == nil {
+				_ = conn.(*tls.Conn).Handshake()
+				accepted <- conn
+			}
+		}()
+
+		var conn2 net.Conn
+		Eventually(accepted, 2*time.Second).Should(Receive(&conn2))
+		conn2.Close()
+	})
+
+	It("does not reconnect when the certificate files are unchanged", func() {
+		spySink := newMutualTLSSpySink("127.0.0.1:0")
+		defer spySink.stop()
+
+		s := &syslog.Sink{
+			Addr:      spySink.url(),
+			Namespace: "some-ns",
+			TLS: &syslog.TLS{
+				RootCA: filepath.Join(dir, "ca.crt"),
+				Cert:   filepath.Join(dir, "client.crt"),
+				Key:    filepath.Join(dir, "client.key"),
+			},
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithTLSReloadInterval(50*time.Millisecond),
+		)
+
+		go func() {
+			defer GinkgoRecover()
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		}()
+		conn := spySink.accept()
+		defer conn.Close()
+		Expect(conn.(*tls.Conn).Handshake()).To(Succeed())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = spySink.lis.Accept()
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+	})
+})
@@ -0,0 +1,105 @@
+package syslog_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("UDP", func() {
+	var (
+		conn   net.PacketConn
+		record map[interface{}]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		conn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("ns1"),
+				"pod_name":       []byte("pod-name"),
+				"container_name": []byte("container-name"),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		conn.Close()
+	})
+
+	readDatagram := func() string {
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return string(buf[:n])
+	}
+
+	It("writes one unframed message per datagram", func() {
+		s := &syslog.Sink{
+			Addr:      conn.LocalAddr().String(),
+			Namespace: "ns1",
+			Transport: syslog.TransportUDP,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		expected := `<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1/pod-name/container-name - - [kubernetes@47450 namespace_name="ns1" object_name="pod-name" container_name="container-name"] some-log` + "\n"
+		Expect(readDatagram()).To(Equal(expected))
+		Expect(readDatagram()).To(Equal(expected))
+		Expect(s.MessagesTruncated()).To(BeZero())
+	})
+
+	It("truncates messages larger than the maximum datagram size", func() {
+		s := &syslog.Sink{
+			Addr:      conn.LocalAddr().String(),
+			Namespace: "ns1",
+			Transport: syslog.TransportUDP,
+		}
+		out := syslog.NewOut(
+			[]*syslog.Sink{s},
+			nil,
+			syslog.WithMaxDatagramSize(32),
+		)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Expect(readDatagram()).To(Equal("<14>1 1970-01-01T00:00:00+00:00 "))
+		Eventually(s.MessagesTruncated).Should(Equal(int64(1)))
+		Expect(s.MessagesDropped()).To(BeZero())
+		Expect(out.SinkState()[0].MessagesTruncated).To(Equal(int64(1)))
+	})
+
+	It("reports an error for unsupported transports", func() {
+		s := &syslog.Sink{
+			Addr:      conn.LocalAddr().String(),
+			Namespace: "ns1",
+			Transport: "carrier-pigeon",
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+		Expect(out.SinkState()[0].Error.Msg).To(Equal(`unsupported transport "carrier-pigeon"`))
+	})
+
+	It("does not support TLS", func() {
+		s := &syslog.Sink{
+			Transport: syslog.TransportUDP,
+			TLS:       &syslog.TLS{},
+		}
+		err := s.Validate()
+		Expect(err).To(MatchError("transport udp does not support TLS"))
+	})
+})
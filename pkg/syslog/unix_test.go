@@ -0,0 +1,126 @@
+package syslog_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Unix", func() {
+	var (
+		dir    string
+		record map[interface{}]interface{}
+	)
+
+	const expected = `<14>1 1970-01-01T00:00:00+00:00 - pod.log/ns1/pod-name/container-name - - [kubernetes@47450 namespace_name="ns1" object_name="pod-name" container_name="container-name"] some-log` + "\n"
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "out-syslog")
+		Expect(err).ToNot(HaveOccurred())
+
+		record = map[interface{}]interface{}{
+			"log": []byte("some-log"),
+			"kubernetes": map[interface{}]interface{}{
+				"namespace_name": []byte("ns1"),
+				"pod_name":       []byte("pod-name"),
+				"container_name": []byte("container-name"),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("stream", func() {
+		It("writes octet counted messages", func() {
+			path := filepath.Join(dir, "stream.sock")
+			lis, err := net.Listen("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer lis.Close()
+
+			s := &syslog.Sink{
+				Addr:      path,
+				Namespace: "ns1",
+				Transport: syslog.TransportUnix,
+			}
+			out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+			conn, err := lis.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			actual, err := bufio.NewReader(conn).ReadString('\n')
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actual).To(Equal(fmt.Sprintf("%d %s", len(expected), expected)))
+		})
+
+		It("tracks errors and reconnects once the socket is available", func() {
+			path := filepath.Join(dir, "stream.sock")
+			s := &syslog.Sink{
+				Addr:      path,
+				Namespace: "ns1",
+				Name:      "sink-name",
+				Transport: syslog.TransportUnix,
+			}
+			out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+			Eventually(s.MessagesDropped).Should(Equal(int64(1)))
+			Expect(out.SinkState()[0].Error).ToNot(BeNil())
+			Expect(out.SinkState()[0].Error.Msg).To(ContainSubstring("dial unix " + path))
+
+			lis, err := net.Listen("unix", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer lis.Close()
+
+			waitForRetry(out)
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+			conn, err := lis.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			conn.Close()
+
+			Eventually(func() *syslog.SinkError {
+				return out.SinkState()[0].Error
+			}).Should(BeNil())
+		})
+	})
+
+	Context("datagram", func() {
+		It("writes one unframed message per datagram", func() {
+			path := filepath.Join(dir, "dgram.sock")
+			conn, err := net.ListenPacket("unixgram", path)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			s := &syslog.Sink{
+				Addr:      path,
+				Namespace: "ns1",
+				Transport: syslog.TransportUnixgram,
+			}
+			out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+			out.Write(record, time.Unix(0, 0).UTC(), "pod.log")
+
+			buf := make([]byte, 65536)
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(buf[:n])).To(Equal(expected))
+		})
+	})
+})
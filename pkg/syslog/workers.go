@@ -0,0 +1,129 @@
+package syslog
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+func validateWorkers(workers int, shardByPod bool, lb string) error {
+	if workers < 0 {
+		return errors.New("workers must not be negative")
+	}
+	if workers <= 1 {
+		if shardByPod {
+			return errors.New("sharding by pod requires more than one worker")
+		}
+		return nil
+	}
+	if lb != "" {
+		return errors.New("load balanced sinks do not support workers")
+	}
+	return nil
+}
+
+// startWorkers starts the sink's workers. Each worker delivers messages on
+// its own connection. Workers of a sink that shards by pod have their own
+// queues, otherwise all workers take messages from the sink's queue.
+func (s *Sink) startWorkers(out *Out) {
+	if !s.ShardByPod {
+		s.messages = make(chan io.WriterTo, s.bufferSize)
+	}
+	bufferSize := s.bufferSize / s.Workers
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	workers := make([]*Sink, 0, s.Workers)
+	for i := 0; i < s.Workers; i++ {
+		w := s.worker()
+		setupTransport(w, out)
+		if s.ShardByPod {
+			w.start(bufferSize)
+		} else {
+			w.messages = s.messages
+			w.run()
+		}
+		workers = append(workers, w)
+	}
+	s.workers = workers
+	s.connections = s.workerStates
+}
+
+// worker returns a sink with the same configuration as s but without any
+// connection or counters.
+func (s *Sink) worker() *Sink {
+	return &Sink{
+		Addr:          s.Addr,
+		Name:          s.Name,
+		Namespace:     s.Namespace,
+		TLS:           s.TLS,
+		Transport:     s.Transport,
+		Framing:       s.Framing,
+		Format:        s.Format,
+		Proxy:         s.Proxy,
+		FailoverAddrs: s.FailoverAddrs,
+		Discovery:     s.Discovery,
+
+		writeTimeout:        s.writeTimeout,
+		tlsReloadInterval:   s.tlsReloadInterval,
+		dialTimeout:         s.dialTimeout,
+		failbackInterval:    s.failbackInterval,
+		resolver:            s.resolver,
+		resolveInterval:     s.resolveInterval,
+		minReconnectBackoff: s.minReconnectBackoff,
+		maxReconnectBackoff: s.maxReconnectBackoff,
+		retryAttempts:       s.retryAttempts,
+		retryMaxAge:         s.retryMaxAge,
+	}
+}
+
+// queue returns the queue msg is added to.
+func (s *Sink) queue(msg io.WriterTo) chan io.WriterTo {
+	if !s.ShardByPod || len(s.workers) == 0 {
+		return s.messages
+	}
+	return s.workers[hash(shardKey(msg))%uint32(len(s.workers))].messages
+}
+
+// workerStates returns the state of the connection of each worker.
+func (s *Sink) workerStates() []ConnectionState {
+	states := make([]ConnectionState, 0, len(s.workers))
+	for _, w := range s.workers {
+		states = append(states, ConnectionState{
+			Addr:                w.activeAddr(),
+			Connected:           atomic.LoadInt32(&w.connected) == 1,
+			LastSuccessfulSend:  loadTime(&w.lastSendSuccessNanos),
+			NextRetry:           loadTime(&w.nextRetryNanos),
+			ConsecutiveFailures: atomic.LoadInt64(&w.consecutiveFailures),
+			Error:               w.LoadSinkError(),
+		})
+	}
+	return states
+}
+
+// aggregateWorkers sets the state of a sink with workers from the state of
+// its workers. The sink reports the most recent successful send and error
+// of any of its workers and the reconnect backoff of the worker with the
+// fewest consecutive failures.
+func (s *Sink) aggregateWorkers(state *SinkState) {
+	for i, w := range s.workers {
+		ws := w.state()
+		if i == 0 || ws.ConsecutiveFailures < state.ConsecutiveFailures {
+			state.ConsecutiveFailures = ws.ConsecutiveFailures
+			state.NextRetry = ws.NextRetry
+		}
+		if ws.LastSuccessfulSend.After(state.LastSuccessfulSend) {
+			state.LastSuccessfulSend = ws.LastSuccessfulSend
+		}
+		if ws.Error != nil && (state.Error == nil || ws.Error.Timestamp.After(state.Error.Timestamp)) {
+			state.Error = ws.Error
+		}
+		if state.ClientCertExpiry == nil {
+			state.ClientCertExpiry = ws.ClientCertExpiry
+		}
+		if state.RootCAExpiry == nil {
+			state.RootCAExpiry = ws.RootCAExpiry
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package syslog_test
+
+import (
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/fluent-bit-out-syslog/pkg/syslog"
+)
+
+var _ = Describe("Workers", func() {
+	var record func(pod, msg string) map[interface{}]interface{}
+
+	BeforeEach(func() {
+		record = func(pod, msg string) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"log": []byte(msg),
+				"kubernetes": map[interface{}]interface{}{
+					"namespace_name": []byte("some-ns"),
+					"pod_name":       []byte(pod),
+				},
+			}
+		}
+	})
+
+	It("delivers messages on several connections", func() {
+		c := newCollector()
+		defer c.stop()
+
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			Workers:   3,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		var expected []string
+		for i := 0; i < 30; i++ {
+			msg := "log-" + strconv.Itoa(i)
+			expected = append(expected, msg+"\n")
+			out.Write(record("pod", msg), time.Unix(0, 0).UTC(), "pod.log")
+		}
+
+		Eventually(c.received).Should(ConsistOf(expected))
+		state := out.SinkState()[0]
+		Expect(state.ActiveAddr).To(BeEmpty())
+		Expect(state.Error).To(BeNil())
+		Expect(state.LastSuccessfulSend.After(time.Unix(0, 0))).To(BeTrue())
+		Expect(state.Connections).To(HaveLen(3))
+		for _, conn := range state.Connections {
+			Expect(conn.Addr).To(Equal(c.url()))
+		}
+	})
+
+	It("keeps the messages of a pod in order when sharding by pod", func() {
+		c := newCollector()
+		defer c.stop()
+
+		s := &syslog.Sink{
+			Addr:       c.url(),
+			Namespace:  "some-ns",
+			Workers:    4,
+			ShardByPod: true,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		pods := []string{"pod-a", "pod-b", "pod-c", "pod-d", "pod-e", "pod-f"}
+		for i := 0; i < 5; i++ {
+			for _, pod := range pods {
+				out.Write(record(pod, pod+"-"+strconv.Itoa(i)), time.Unix(0, 0).UTC(), "pod.log")
+			}
+		}
+
+		Eventually(c.received).Should(HaveLen(5 * len(pods)))
+		for _, pod := range pods {
+			var expected []string
+			for i := 0; i < 5; i++ {
+				expected = append(expected, pod+"-"+strconv.Itoa(i)+"\n")
+			}
+
+			var found bool
+			for _, msgs := range c.receivedByConn() {
+				var podMsgs []string
+				for _, msg := range msgs {
+					if len(msg) > len(pod) && msg[:len(pod)+1] == pod+"-" {
+						podMsgs = append(podMsgs, msg)
+					}
+				}
+				if len(podMsgs) != 0 {
+					Expect(found).To(BeFalse(), "messages of %s were sent on several connections", pod)
+					Expect(podMsgs).To(Equal(expected))
+					found = true
+				}
+			}
+			Expect(found).To(BeTrue())
+		}
+	})
+
+	It("reports the state of each worker", func() {
+		c := newCollector()
+		c.stop()
+
+		s := &syslog.Sink{
+			Addr:      c.url(),
+			Namespace: "some-ns",
+			Workers:   2,
+		}
+		out := syslog.NewOut([]*syslog.Sink{s}, nil)
+
+		out.Write(record("pod", "log-1"), time.Unix(0, 0).UTC(), "pod.log")
+		out.Write(record("pod", "log-2"), time.Unix(0, 0).UTC(), "pod.log")
+
+		Eventually(s.MessagesDropped).Should(Equal(int64(2)))
+		state := out.SinkState()[0]
+		Expect(state.Error).ToNot(BeNil())
+		Expect(state.Connections).To(HaveLen(2))
+		for _, conn := range state.Connections {
+			Expect(conn.Connected).To(BeFalse())
+			Expect(conn.LastSuccessfulSend).To(BeNil())
+		}
+	})
+
+	DescribeTable("validates the workers", func(workers int, shardByPod bool, lb string, valid bool) {
+		s := &syslog.Sink{
+			Addr:          "localhost:514",
+			Workers:       workers,
+			ShardByPod:    shardByPod,
+			LoadBalancing: lb,
+		}
+
+		err := s.Validate()
+
+		if valid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("several workers", 4, false, "", true),
+		Entry("sharding by pod", 4, true, "", true),
+		Entry("negative workers", -1, false, "", false),
+		Entry("sharding a single worker", 1, true, "", false),
+		Entry("load balancing", 2, false, syslog.LoadBalancingHash, false),
+	)
+})